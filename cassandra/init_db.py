@@ -1,3 +1,4 @@
+from cassandra import InvalidRequest
 from cassandra.cluster import Cluster
 import os
 import time
@@ -78,6 +79,30 @@ def create_parsers_table(session):
     session.execute(create_table_query)
     print("Table 'parsers' created successfully")
 
+def add_column(session, table, column, column_type):
+    """Add a column to an existing table, doing nothing if it is already there"""
+    try:
+        session.execute(f"ALTER TABLE {table} ADD {column} {column_type}")
+        print(f"Column '{column}' added to '{table}'")
+    except InvalidRequest as e:
+        # Cassandra 4.1+ reports "already exists", earlier versions a conflicting column
+        message = str(e)
+        if "already exists" not in message and "conflicts with an existing column" not in message:
+            raise
+
+# Columns added to embeddings after it was first created, as (name, CQL type).
+# CREATE TABLE IF NOT EXISTS leaves an existing table alone, so they are added here.
+EMBEDDINGS_ADDED_COLUMNS = [
+    ("lecture_start_ms", "bigint"),
+    ("lecture_end_timestamp", "text"),
+    ("lecture_order", "int"),
+    ("keywords", "list<text>"),
+    ("continues_previous", "boolean"),
+    ("untimed", "boolean"),
+    ("model_name", "text"),
+    ("embedding_dim", "int"),
+]
+
 def create_embeddings_table(session):
     """Create embeddings table for storing chunk embeddings with vector search"""
     print(f"\nCreating table: {CASSANDRA_KEYSPACE}.embeddings")
@@ -96,6 +121,14 @@ def create_embeddings_table(session):
         token_count int,
         lecture_title text,
         lecture_timestamp text,
+        lecture_start_ms bigint,
+        lecture_end_timestamp text,
+        lecture_order int,
+        keywords list<text>,
+        continues_previous boolean,
+        untimed boolean,
+        model_name text,
+        embedding_dim int,
         created_at timestamp,
         PRIMARY KEY ((class_name, professor, semester), url, chunk_index)
     )
@@ -104,6 +137,9 @@ def create_embeddings_table(session):
     session.execute(create_table_query)
     print("Table 'embeddings' created successfully")
 
+    for column, column_type in EMBEDDINGS_ADDED_COLUMNS:
+        add_column(session, "embeddings", column, column_type)
+
     # Create ANN index for vector search
     embedding_index_query = """
     CREATE INDEX IF NOT EXISTS embedding_idx
@@ -113,6 +149,53 @@ def create_embeddings_table(session):
     session.execute(embedding_index_query)
     print("Embedding index index 'embedding_idx' created successfully")
 
+def create_sentence_embedding_cache_table(session):
+    """Create sentence_embedding_cache table for reusing sentence embeddings on reprocess"""
+    print(f"\nCreating table: {CASSANDRA_KEYSPACE}.sentence_embedding_cache")
+
+    session.set_keyspace(CASSANDRA_KEYSPACE)
+
+    create_table_query = """
+    CREATE TABLE IF NOT EXISTS sentence_embedding_cache (
+        class_name text,
+        professor text,
+        semester text,
+        url text,
+        text_hash text,
+        embedding VECTOR<FLOAT, 1024>,
+        PRIMARY KEY ((class_name, professor, semester, url), text_hash)
+    )
+    """
+
+    session.execute(create_table_query)
+    print("Table 'sentence_embedding_cache' created successfully")
+
+def create_sentence_embeddings_table(session):
+    """Create sentence_embeddings table for sentence-level (highlight) search"""
+    print(f"\nCreating table: {CASSANDRA_KEYSPACE}.sentence_embeddings")
+
+    session.set_keyspace(CASSANDRA_KEYSPACE)
+
+    create_table_query = """
+    CREATE TABLE IF NOT EXISTS sentence_embeddings (
+        class_name text,
+        professor text,
+        semester text,
+        url text,
+        sentence_index int,
+        chunk_index int,
+        sentence_text text,
+        embedding VECTOR<FLOAT, 1024>,
+        lecture_timestamp text,
+        lecture_start_ms bigint,
+        model_name text,
+        PRIMARY KEY ((class_name, professor, semester), url, sentence_index)
+    )
+    """
+
+    session.execute(create_table_query)
+    print("Table 'sentence_embeddings' created successfully")
+
 def create_inverted_index_table(session):
     """Create inverted index table for keyword search"""
     print(f"\nCreating table: {CASSANDRA_KEYSPACE}.keywords")
@@ -210,6 +293,8 @@ def main():
         create_transcript_table(session)
         create_parsers_table(session)
         create_embeddings_table(session)
+        create_sentence_embedding_cache_table(session)
+        create_sentence_embeddings_table(session)
         create_inverted_index_table(session)
         create_piazza_answers_table(session)
         create_piazza_config_table(session)
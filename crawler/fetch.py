@@ -18,6 +18,7 @@ from kafka.errors import KafkaError
 REDIS_HOST = os.getenv('REDIS_HOST', 'localhost')
 REDIS_PORT = int(os.getenv('REDIS_PORT', 6379))
 REDIS_QUEUE = os.getenv('REDIS_QUEUE', 'frontier')
+REDIS_PRIORITY_QUEUE = os.getenv('REDIS_PRIORITY_QUEUE', REDIS_QUEUE + ':priority')
 
 CASSANDRA_HOSTS = os.getenv('CASSANDRA_HOSTS', 'localhost').split(',')
 CASSANDRA_KEYSPACE = os.getenv('CASSANDRA_KEYSPACE', 'transcript_db')
@@ -143,11 +144,15 @@ def main():
 
     while True:
         try:
-            # wait for a lecture JSON from the redis queue
-            result = r.blpop(REDIS_QUEUE)
-
+            # prefer the highest-priority lecture, otherwise wait briefly on the FIFO queue
+            result = r.zpopmax(REDIS_PRIORITY_QUEUE)
             if result:
-                _, json_str = result
+                json_str, _ = result[0]
+            else:
+                result = r.blpop(REDIS_QUEUE, timeout=5)
+                json_str = result[1] if result else None
+
+            if json_str:
 
                 lecture = json.loads(json_str)
 
@@ -181,7 +186,8 @@ def main():
                         "semester": lecture.get("semester"),
                         "url": url,
                         "lecture_number": lecture.get("lecture_number"),
-                        "lecture_title": lecture.get("lecture_title")
+                        "lecture_title": lecture.get("lecture_title"),
+                        "priority": lecture.get("priority", 0)
                     }
 
                     try:
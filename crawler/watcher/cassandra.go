@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"regexp"
 	"strings"
 	"time"
 
-	"github.com/gocql/gocql"
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
+
+	"piazza-bot/shared/cassandra"
 )
 
 // Parser represents a parser record from Cassandra
@@ -22,30 +27,70 @@ type PiazzaConfig struct {
 	Professor string
 	Semester  string
 	Email     string
-	Password  string
+	Password  string // plaintext in memory; never logged, see LogValue/String
+}
+
+// LogValue implements slog.LogValuer so a logged config never includes the password
+func (c PiazzaConfig) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("network_id", c.NetworkID),
+		slog.String("class_name", c.ClassName),
+		slog.String("professor", c.Professor),
+		slog.String("semester", c.Semester),
+		slog.String("email", c.Email),
+		slog.String("password", "[REDACTED]"),
+	)
+}
+
+// String redacts the password when a config is formatted with %v
+func (c PiazzaConfig) String() string {
+	return fmt.Sprintf("{NetworkID:%s ClassName:%s Professor:%s Semester:%s Email:%s Password:[REDACTED]}",
+		c.NetworkID, c.ClassName, c.Professor, c.Semester, c.Email)
 }
 
 // ConnectCassandra establishes a connection to Cassandra
 func ConnectCassandra(config *Config) (*gocql.Session, error) {
-	cluster := gocql.NewCluster(config.CassandraHosts...)
-	cluster.Keyspace = config.CassandraKeyspace
-	cluster.Consistency = gocql.Quorum
-	cluster.Timeout = 10 * time.Second
-	cluster.ConnectTimeout = 10 * time.Second
+	return cassandra.Connect(config.CassandraHosts, config.CassandraKeyspace, config.CassandraOptions)
+}
 
-	session, err := cluster.CreateSession()
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Cassandra: %w", err)
+// FetchParsers retrieves all parsers from Cassandra, making up to attempts tries with
+// exponential backoff on transient errors (timeouts, unavailable replicas, lost connections)
+func FetchParsers(ctx context.Context, session *gocql.Session, attempts int, backoff time.Duration) ([]Parser, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var parsers []Parser
+		parsers, err = fetchParsersOnce(ctx, session)
+		if err == nil || !isTransientCassandraError(err) || attempt >= attempts {
+			return parsers, err
+		}
+
+		slog.Warn("Fetching parsers failed, retrying",
+			"attempt", attempt, "attempts", attempts, "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error fetching parsers: %w", ctx.Err())
+		}
+		backoff *= 2
 	}
+}
+
+// isTransientCassandraError reports whether err may succeed if the query is retried
+func isTransientCassandraError(err error) bool {
+	var readTimeout *gocql.RequestErrReadTimeout
+	var unavailable *gocql.RequestErrUnavailable
 
-	return session, nil
+	return errors.As(err, &readTimeout) ||
+		errors.As(err, &unavailable) ||
+		errors.Is(err, gocql.ErrTimeoutNoResponse) ||
+		errors.Is(err, gocql.ErrNoConnections)
 }
 
-// FetchParsers retrieves all parsers from Cassandra
-func FetchParsers(session *gocql.Session) ([]Parser, error) {
+// fetchParsersOnce runs the parsers query once
+func fetchParsersOnce(ctx context.Context, session *gocql.Session) ([]Parser, error) {
 	query := `SELECT parser_name, code_text FROM parsers`
 
-	iter := session.Query(query).Iter()
+	iter := session.Query(query).IterContext(ctx)
 	defer iter.Close()
 
 	var parsers []Parser
@@ -63,11 +108,29 @@ func FetchParsers(session *gocql.Session) ([]Parser, error) {
 	return parsers, nil
 }
 
-// ExtractPiazzaConfig extracts Piazza configuration from parser comment headers
+// ErrNoPiazzaConfig is returned by ExtractPiazzaConfig when a parser has no Piazza header at all
+var ErrNoPiazzaConfig = errors.New("no Piazza config found")
+
+// piazzaHeaderPattern matches a "# KEY: value" comment line. Keys are case-insensitive,
+// whitespace around the colon is allowed, and the value stops before any \r or \n.
+func piazzaHeaderPattern(key string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)#[ \t]*` + key + `[ \t]*:[ \t]*([^\r\n]+)`)
+}
+
+var (
+	classNamePattern       = piazzaHeaderPattern("CLASS_NAME")
+	professorPattern       = piazzaHeaderPattern("PROFESSOR")
+	semesterPattern        = piazzaHeaderPattern("SEMESTER")
+	piazzaNetworkIDPattern = piazzaHeaderPattern("PIAZZA_NETWORK_ID")
+	piazzaEmailPattern     = piazzaHeaderPattern("PIAZZA_EMAIL")
+	piazzaPasswordPattern  = piazzaHeaderPattern("PIAZZA_PASSWORD")
+)
+
+// ExtractPiazzaConfig extracts Piazza configuration from parser comment headers.
+// A header with only some of the required fields is an error naming the missing ones.
 func ExtractPiazzaConfig(codeText string) (*PiazzaConfig, error) {
 	// Extract values using regex
-	extractField := func(pattern string) string {
-		re := regexp.MustCompile(pattern)
+	extractField := func(re *regexp.Regexp) string {
 		match := re.FindStringSubmatch(codeText)
 		if len(match) > 1 {
 			return strings.TrimSpace(match[1])
@@ -76,25 +139,41 @@ func ExtractPiazzaConfig(codeText string) (*PiazzaConfig, error) {
 	}
 
 	config := &PiazzaConfig{
-		ClassName: extractField(`#\s*CLASS_NAME:\s*(.+)`),
-		Professor: extractField(`#\s*PROFESSOR:\s*(.+)`),
-		Semester:  extractField(`#\s*SEMESTER:\s*(.+)`),
-		NetworkID: extractField(`#\s*PIAZZA_NETWORK_ID:\s*(.+)`),
-		Email:     extractField(`#\s*PIAZZA_EMAIL:\s*(.+)`),
-		Password:  extractField(`#\s*PIAZZA_PASSWORD:\s*(.+)`),
+		ClassName: extractField(classNamePattern),
+		Professor: extractField(professorPattern),
+		Semester:  extractField(semesterPattern),
+		NetworkID: extractField(piazzaNetworkIDPattern),
+		Email:     extractField(piazzaEmailPattern),
+		Password:  extractField(piazzaPasswordPattern),
+	}
+
+	if *config == (PiazzaConfig{}) {
+		return nil, ErrNoPiazzaConfig
 	}
 
 	// Check if we have the minimum required fields
-	if config.NetworkID == "" || config.ClassName == "" || config.Professor == "" || config.Semester == "" {
-		return nil, fmt.Errorf("missing required Piazza config fields")
+	var missing []string
+	for _, field := range []struct{ name, value string }{
+		{"PIAZZA_NETWORK_ID", config.NetworkID},
+		{"CLASS_NAME", config.ClassName},
+		{"PROFESSOR", config.Professor},
+		{"SEMESTER", config.Semester},
+	} {
+		if field.value == "" {
+			missing = append(missing, field.name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required Piazza config fields: %s", strings.Join(missing, ", "))
 	}
 
 	return config, nil
 }
 
 // UpsertPiazzaConfig inserts or updates Piazza configuration in Cassandra
-// Only updates (and resets timestamp) if the config values have changed
-func UpsertPiazzaConfig(session *gocql.Session, config *PiazzaConfig) error {
+// Only updates (and resets timestamp) if the config values have changed.
+// The password is encrypted with passwords first (nil stores plaintext).
+func UpsertPiazzaConfig(session *gocql.Session, config *PiazzaConfig, passwords *PasswordCipher) error {
 	// First, check if existing config matches
 	checkQuery := `SELECT class_name, professor, semester, email, password
 	               FROM piazza_config WHERE network_id = ?`
@@ -108,18 +187,28 @@ func UpsertPiazzaConfig(session *gocql.Session, config *PiazzaConfig) error {
 		&existingPassword,
 	)
 
-	// If row exists and all values match, do nothing
+	// If row exists and all values match, do nothing. Encryption uses a random nonce,
+	// so the stored password is compared after decrypting; a row that can't be decrypted,
+	// or is still plaintext while a key is configured, is rewritten.
 	if err == nil {
-		if existingClassName == config.ClassName &&
+		decrypted, decryptErr := passwords.Decrypt(existingPassword)
+		encryptedAsConfigured := passwords == nil || strings.HasPrefix(existingPassword, encryptedPasswordPrefix)
+		if decryptErr == nil && encryptedAsConfigured &&
+			existingClassName == config.ClassName &&
 			existingProfessor == config.Professor &&
 			existingSemester == config.Semester &&
 			existingEmail == config.Email &&
-			existingPassword == config.Password {
+			decrypted == config.Password {
 			// Config unchanged, skip update
 			return nil
 		}
 	}
 
+	storedPassword, err := passwords.Encrypt(config.Password)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt Piazza password: %w", err)
+	}
+
 	// Config changed or doesn't exist - insert/update with new timestamp
 	insertQuery := `INSERT INTO piazza_config (network_id, class_name, professor, semester, email, password, created_at)
 	                VALUES (?, ?, ?, ?, ?, ?, toTimestamp(now()))`
@@ -130,7 +219,7 @@ func UpsertPiazzaConfig(session *gocql.Session, config *PiazzaConfig) error {
 		config.Professor,
 		config.Semester,
 		config.Email,
-		config.Password,
+		storedPassword,
 	).Exec(); err != nil {
 		return fmt.Errorf("failed to upsert Piazza config: %w", err)
 	}
@@ -138,6 +227,70 @@ func UpsertPiazzaConfig(session *gocql.Session, config *PiazzaConfig) error {
 	return nil
 }
 
+// ErrPiazzaConfigNotFound is returned when no Piazza config exists for a network ID
+var ErrPiazzaConfigNotFound = errors.New("Piazza config not found")
+
+// fetchPiazzaConfigColumns are the piazza_config columns scanned into a PiazzaConfig
+const fetchPiazzaConfigColumns = `network_id, class_name, professor, semester, email, password`
+
+// decryptPiazzaConfig decrypts the stored password of a scanned row in place
+func decryptPiazzaConfig(config *PiazzaConfig, passwords *PasswordCipher) error {
+	password, err := passwords.Decrypt(config.Password)
+	if err != nil {
+		return fmt.Errorf("Piazza config %s: %w", config.NetworkID, err)
+	}
+	config.Password = password
+	return nil
+}
+
+// FetchPiazzaConfig reads the Piazza config of one network, decrypting its password
+// with passwords (nil reads plaintext). Returns ErrPiazzaConfigNotFound if there is none.
+func FetchPiazzaConfig(session *gocql.Session, networkID string, passwords *PasswordCipher) (*PiazzaConfig, error) {
+	query := `SELECT ` + fetchPiazzaConfigColumns + ` FROM piazza_config WHERE network_id = ?`
+
+	var config PiazzaConfig
+	err := session.Query(query, networkID).Scan(
+		&config.NetworkID, &config.ClassName, &config.Professor,
+		&config.Semester, &config.Email, &config.Password,
+	)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return nil, fmt.Errorf("%w: network_id=%s", ErrPiazzaConfigNotFound, networkID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching Piazza config: %w", err)
+	}
+
+	if err := decryptPiazzaConfig(&config, passwords); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// FetchAllPiazzaConfigs reads every Piazza config, decrypting passwords with passwords
+func FetchAllPiazzaConfigs(session *gocql.Session, passwords *PasswordCipher) ([]PiazzaConfig, error) {
+	query := `SELECT ` + fetchPiazzaConfigColumns + ` FROM piazza_config`
+
+	iter := session.Query(query).Iter()
+	defer iter.Close()
+
+	var configs []PiazzaConfig
+	var config PiazzaConfig
+	for iter.Scan(&config.NetworkID, &config.ClassName, &config.Professor,
+		&config.Semester, &config.Email, &config.Password) {
+		if err := decryptPiazzaConfig(&config, passwords); err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+		config = PiazzaConfig{} // Reset for next iteration
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("error fetching Piazza configs: %w", err)
+	}
+
+	return configs, nil
+}
+
 // DeletePiazzaConfig deletes Piazza configuration from Cassandra
 func DeletePiazzaConfig(session *gocql.Session, networkID string) error {
 	query := `DELETE FROM piazza_config WHERE network_id = ?`
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExtractPiazzaConfig(t *testing.T) {
+	want := PiazzaConfig{
+		NetworkID: "abc123",
+		ClassName: "CS101",
+		Professor: "Ada Lovelace",
+		Semester:  "Fall 2025",
+		Email:     "ta@example.com",
+		Password:  "hunter2",
+	}
+
+	tests := []struct {
+		name string
+		code string
+	}{
+		{
+			name: "uppercase keys",
+			code: "# PIAZZA_NETWORK_ID: abc123\n# CLASS_NAME: CS101\n# PROFESSOR: Ada Lovelace\n" +
+				"# SEMESTER: Fall 2025\n# PIAZZA_EMAIL: ta@example.com\n# PIAZZA_PASSWORD: hunter2\nprint()\n",
+		},
+		{
+			name: "mixed-case keys and spaced colons",
+			code: "#piazza_network_id : abc123\n# class_name : CS101\n#\tProfessor:Ada Lovelace\n" +
+				"# Semester :  Fall 2025  \n# Piazza_Email: ta@example.com\n# piazza_PASSWORD: hunter2\n",
+		},
+		{
+			name: "CRLF line endings",
+			code: "# PIAZZA_NETWORK_ID: abc123\r\n# CLASS_NAME: CS101\r\n# PROFESSOR: Ada Lovelace\r\n" +
+				"# SEMESTER: Fall 2025\r\n# PIAZZA_EMAIL: ta@example.com\r\n# PIAZZA_PASSWORD: hunter2\r\nprint()\r\n",
+		},
+		{
+			name: "mixed-case keys with CRLF",
+			code: "# Piazza_Network_Id: abc123\r\n# class_name : CS101\r\n# professor: Ada Lovelace\r\n" +
+				"# semester: Fall 2025\r\n# piazza_email: ta@example.com\r\n# piazza_password: hunter2\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractPiazzaConfig(tt.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != want {
+				t.Errorf("ExtractPiazzaConfig() = %v, want %v", *got, want)
+			}
+			// String redacts the password, so report it separately
+			if got.Password != want.Password {
+				t.Errorf("Password = %q, want %q", got.Password, want.Password)
+			}
+		})
+	}
+}
+
+func TestExtractPiazzaConfigMissingHeader(t *testing.T) {
+	if _, err := ExtractPiazzaConfig("print('no header')\r\n"); !errors.Is(err, ErrNoPiazzaConfig) {
+		t.Errorf("err = %v, want ErrNoPiazzaConfig", err)
+	}
+
+	_, err := ExtractPiazzaConfig("# class_name: CS101\r\n# professor: Ada Lovelace\r\n")
+	if err == nil || errors.Is(err, ErrNoPiazzaConfig) {
+		t.Errorf("err = %v, want an error naming the missing fields", err)
+	}
+}
@@ -1,35 +1,134 @@
 package main
 
 import (
+	"log/slog"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"piazza-bot/shared/cassandra"
 )
 
 // Config holds configuration from environment variables
 type Config struct {
-	CassandraHosts    []string
-	CassandraKeyspace string
-	PollInterval      time.Duration
-	ParsersDir        string
-	RedisHost         string
-	RedisPort         string
-	RedisQueue        string
-	RedisSeenSet      string
+	CassandraHosts         []string
+	CassandraKeyspace      string
+	CassandraOptions       cassandra.Options
+	PollInterval           time.Duration
+	CycleTimeout           time.Duration
+	ParsersDir             string
+	Interpreters           map[string]string
+	ParserTimeout          time.Duration
+	StrictParserOutput     bool
+	ParserRerunInterval    time.Duration
+	ForceParserRun         bool
+	Sandbox                SandboxConfig
+	RedisHost              string
+	RedisPort              string
+	RedisQueue             string
+	RedisSeenSet           string
+	SeenTTL                time.Duration
+	RedisPriorityQueue     string
+	ClassPriorities        map[string]int
+	RedisPassword          string
+	RedisDB                int
+	RedisTLS               bool
+	RedisReconnectAttempts int
+	RedisReconnectBackoff  time.Duration
+	CassandraFetchAttempts int
+	CassandraFetchBackoff  time.Duration
+	AllowEmptyCleanup      bool
+	HealthAddr             string
+	LivenessTimeout        time.Duration
+	ShutdownTimeout        time.Duration
+	PiazzaEncryptionKey    string
 }
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() *Config {
-	hostsEnv := os.Getenv("CASSANDRA_HOSTS")
-
-	// Split comma-separated hosts
-	hosts := strings.Split(hostsEnv, ",")
+	// Comma-separated hosts, defaulting to the same three nodes as the processor
+	hosts := cassandra.SplitHosts(os.Getenv("CASSANDRA_HOSTS"))
+	if len(hosts) == 0 {
+		hosts = []string{"db-1", "db-2", "db-3"}
+	}
 
 	keyspace := os.Getenv("CASSANDRA_KEYSPACE")
 
+	// Time between the starts of consecutive cycles, e.g. POLL_INTERVAL=2m
 	pollInterval := 60 * time.Second
+	if v := os.Getenv("POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			pollInterval = d
+		} else {
+			slog.Warn("Invalid POLL_INTERVAL, must be a positive duration; using the default",
+				"value", v, "default", pollInterval)
+		}
+	}
+
+	// Deadline for a whole update+run cycle; an overrunning cycle is cancelled (0 disables)
+	cycleTimeout := 30 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("CYCLE_TIMEOUT")); err == nil {
+		cycleTimeout = v
+	}
 
 	parsersDir := "./parsers"
+	if v := os.Getenv("PARSERS_DIR"); v != "" {
+		parsersDir = v
+	}
+
+	// Extension -> interpreter, extended or overridden by e.g. PARSER_INTERPRETERS=".rb=ruby,.js=node"
+	interpreters := make(map[string]string)
+	for ext, command := range DefaultInterpreters {
+		interpreters[ext] = command
+	}
+	for _, pair := range strings.Split(os.Getenv("PARSER_INTERPRETERS"), ",") {
+		ext, command, ok := strings.Cut(pair, "=")
+		ext, command = strings.TrimSpace(ext), strings.TrimSpace(command)
+		if !ok || ext == "" || command == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		interpreters[ext] = command
+	}
+
+	// Max runtime of a single parser before its process group is killed (0 disables)
+	parserTimeout := 2 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("PARSER_TIMEOUT")); err == nil {
+		parserTimeout = v
+	}
+
+	strictParserOutput, _ := strconv.ParseBool(os.Getenv("STRICT_PARSER_OUTPUT"))
+
+	// Unchanged parsers with stable output are only re-executed this often
+	parserRerunInterval := 15 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("PARSER_RERUN_INTERVAL")); err == nil {
+		parserRerunInterval = v
+	}
+
+	// Run every parser every cycle regardless of changes
+	forceParserRun, _ := strconv.ParseBool(os.Getenv("FORCE_PARSER_RUN"))
+
+	// Resource and environment restrictions for untrusted parser code
+	sandbox := SandboxConfig{
+		WorkDirPattern: "parser-*",
+	}
+	sandbox.Enabled, _ = strconv.ParseBool(os.Getenv("PARSER_SANDBOX"))
+	if v, err := strconv.Atoi(os.Getenv("PARSER_MEMORY_LIMIT_MB")); err == nil && v > 0 {
+		sandbox.MemoryLimitMB = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("PARSER_CPU_LIMIT")); err == nil && v > 0 {
+		sandbox.CPULimit = v
+	}
+	if v := os.Getenv("PARSER_ALLOWED_ENV"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				sandbox.AllowedEnv = append(sandbox.AllowedEnv, name)
+			}
+		}
+	}
 
 	redisHost := os.Getenv("REDIS_HOST")
 
@@ -39,14 +138,104 @@ func LoadConfig() *Config {
 
 	redisSeenSet := os.Getenv("REDIS_SEEN_SET")
 
+	// How long a URL stays seen before it can be re-queued (0 = forever)
+	var seenTTL time.Duration
+	if v, err := time.ParseDuration(os.Getenv("SEEN_TTL")); err == nil && v > 0 {
+		seenTTL = v
+	}
+
+	// Sorted-set queue for lectures of classes listed in CLASS_PRIORITIES ("CS 544=10,CS 537=5")
+	redisPriorityQueue := os.Getenv("REDIS_PRIORITY_QUEUE")
+	if redisPriorityQueue == "" {
+		redisPriorityQueue = redisQueue + ":priority"
+	}
+	classPriorities := make(map[string]int)
+	for _, pair := range strings.Split(os.Getenv("CLASS_PRIORITIES"), ",") {
+		className, priority, ok := strings.Cut(pair, "=")
+		if v, err := strconv.Atoi(strings.TrimSpace(priority)); ok && err == nil && v > 0 {
+			classPriorities[strings.TrimSpace(className)] = v
+		}
+	}
+
+	// AUTH, DB index, and TLS default to none, 0, and off
+	redisDB, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+	redisTLS, _ := strconv.ParseBool(os.Getenv("REDIS_TLS"))
+
+	// Reconnects tried after a Redis connection error before the command fails
+	redisReconnectAttempts := 5
+	if v, err := strconv.Atoi(os.Getenv("REDIS_RECONNECT_ATTEMPTS")); err == nil && v >= 0 {
+		redisReconnectAttempts = v
+	}
+	redisReconnectBackoff := time.Second
+	if v, err := time.ParseDuration(os.Getenv("REDIS_RECONNECT_BACKOFF")); err == nil && v > 0 {
+		redisReconnectBackoff = v
+	}
+
+	// Retries of the parsers query on transient Cassandra errors
+	cassandraFetchAttempts := 3
+	if v, err := strconv.Atoi(os.Getenv("CASSANDRA_FETCH_ATTEMPTS")); err == nil && v > 0 {
+		cassandraFetchAttempts = v
+	}
+	cassandraFetchBackoff := time.Second
+	if v, err := time.ParseDuration(os.Getenv("CASSANDRA_FETCH_BACKOFF")); err == nil && v > 0 {
+		cassandraFetchBackoff = v
+	}
+
+	// An empty parsers table deletes every parser on disk only when explicitly allowed
+	allowEmptyParserCleanup, _ := strconv.ParseBool(os.Getenv("ALLOW_EMPTY_PARSER_CLEANUP"))
+
+	// Listen address for /healthz and /readyz (set empty to disable)
+	healthAddr := ":8080"
+	if v, ok := os.LookupEnv("HEALTH_ADDR"); ok {
+		healthAddr = v
+	}
+
+	// /healthz fails if no cycle has started within this long
+	livenessTimeout := cycleTimeout + pollInterval + 5*time.Minute
+	if v, err := time.ParseDuration(os.Getenv("LIVENESS_TIMEOUT")); err == nil && v > 0 {
+		livenessTimeout = v
+	}
+
+	// After SIGINT/SIGTERM, time the current cycle gets to finish before it is cancelled
+	shutdownTimeout := 30 * time.Second
+	if v, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && v > 0 {
+		shutdownTimeout = v
+	}
+
+	// Base64 32-byte AES key for Piazza passwords at rest (unset stores plaintext)
+	piazzaEncryptionKey := os.Getenv("PIAZZA_ENCRYPTION_KEY")
+
 	return &Config{
-		CassandraHosts:    hosts,
-		CassandraKeyspace: keyspace,
-		PollInterval:      pollInterval,
-		ParsersDir:        parsersDir,
-		RedisHost:         redisHost,
-		RedisPort:         redisPort,
-		RedisQueue:        redisQueue,
-		RedisSeenSet:      redisSeenSet,
+		CassandraHosts:         hosts,
+		CassandraKeyspace:      keyspace,
+		CassandraOptions:       cassandra.OptionsFromEnv(), // Password auth and TLS are off unless configured
+		PollInterval:           pollInterval,
+		CycleTimeout:           cycleTimeout,
+		ParsersDir:             parsersDir,
+		Interpreters:           interpreters,
+		ParserTimeout:          parserTimeout,
+		StrictParserOutput:     strictParserOutput,
+		ParserRerunInterval:    parserRerunInterval,
+		ForceParserRun:         forceParserRun,
+		Sandbox:                sandbox,
+		RedisHost:              redisHost,
+		RedisPort:              redisPort,
+		RedisQueue:             redisQueue,
+		RedisSeenSet:           redisSeenSet,
+		SeenTTL:                seenTTL,
+		RedisPriorityQueue:     redisPriorityQueue,
+		ClassPriorities:        classPriorities,
+		RedisPassword:          os.Getenv("REDIS_PASSWORD"),
+		RedisDB:                redisDB,
+		RedisTLS:               redisTLS,
+		RedisReconnectAttempts: redisReconnectAttempts,
+		RedisReconnectBackoff:  redisReconnectBackoff,
+		CassandraFetchAttempts: cassandraFetchAttempts,
+		CassandraFetchBackoff:  cassandraFetchBackoff,
+		AllowEmptyCleanup:      allowEmptyParserCleanup,
+		HealthAddr:             healthAddr,
+		LivenessTimeout:        livenessTimeout,
+		ShutdownTimeout:        shutdownTimeout,
+		PiazzaEncryptionKey:    piazzaEncryptionKey,
 	}
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedPasswordPrefix marks a password stored as base64(nonce || AES-GCM ciphertext).
+// Values without it are legacy plaintext and are read back unchanged.
+const encryptedPasswordPrefix = "enc:v1:"
+
+// PasswordCipher encrypts Piazza passwords at rest with AES-256-GCM.
+// A nil cipher (no key configured) stores and reads passwords as plaintext.
+type PasswordCipher struct {
+	aead cipher.AEAD
+}
+
+// NewPasswordCipher builds a cipher from a base64-encoded 32-byte key, or returns nil
+// if keyBase64 is empty
+func NewPasswordCipher(keyBase64 string) (*PasswordCipher, error) {
+	if keyBase64 == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(keyBase64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid encryption key: got %d bytes, want 32", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return &PasswordCipher{aead: aead}, nil
+}
+
+// Encrypt returns the value to store for password
+func (c *PasswordCipher) Encrypt(password string) (string, error) {
+	if c == nil || password == "" {
+		return password, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(password), nil)
+	return encryptedPasswordPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plaintext of a stored password. Plaintext values pass through.
+func (c *PasswordCipher) Decrypt(stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, encryptedPasswordPrefix)
+	if !ok {
+		return stored, nil
+	}
+	if c == nil {
+		return "", errors.New("password is encrypted but no encryption key is configured")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted password: %w", err)
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("malformed encrypted password: too short")
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt password: %w", err)
+	}
+	return string(plaintext), nil
+}
@@ -2,13 +2,23 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// ErrParserTimeout is returned by ExecuteParser when a parser exceeds its timeout
+var ErrParserTimeout = errors.New("parser timed out")
+
 // LectureInfo represents a lecture parsed from a Python parser
 type LectureInfo struct {
 	ClassName    string `json:"class_name"`
@@ -16,16 +26,132 @@ type LectureInfo struct {
 	Semester     string `json:"semester"`
 	URL          string `json:"url"`
 	LectureTitle string `json:"lecture_title"`
+	Priority     int    `json:"priority,omitempty"` // set when queued with AddLectureWithPriority
 }
 
-// ExecuteParser runs a Python parser and returns the lecture info it outputs
-func ExecuteParser(parserName, parsersDir string) ([]LectureInfo, error) {
-	parserPath := filepath.Join(parsersDir, parserName+".py")
+// IsEmpty reports whether every field of the lecture is blank
+func (l LectureInfo) IsEmpty() bool {
+	return strings.TrimSpace(l.ClassName) == "" &&
+		strings.TrimSpace(l.Professor) == "" &&
+		strings.TrimSpace(l.Semester) == "" &&
+		strings.TrimSpace(l.URL) == "" &&
+		strings.TrimSpace(l.LectureTitle) == ""
+}
 
-	log.Printf("  Executing %s...", parserName)
+// Validate checks that the fields the pipeline keys on are present
+func (l LectureInfo) Validate() error {
+	var missing []string
+	if strings.TrimSpace(l.URL) == "" {
+		missing = append(missing, "url")
+	}
+	if strings.TrimSpace(l.ClassName) == "" {
+		missing = append(missing, "class_name")
+	}
+	if strings.TrimSpace(l.Professor) == "" {
+		missing = append(missing, "professor")
+	}
+	if strings.TrimSpace(l.Semester) == "" {
+		missing = append(missing, "semester")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
 
-	// Run the Python script
-	cmd := exec.Command("python3", parserPath)
+// ParserResult is the output of one parser run
+type ParserResult struct {
+	Lectures []LectureInfo
+	Rejected int // lines skipped for failing to parse or validate
+}
+
+// DefaultInterpreters maps parser file extensions to the command that runs them
+var DefaultInterpreters = map[string]string{
+	".py": "python3",
+	".js": "node",
+	".sh": "bash",
+}
+
+// ParserFileName returns the file a parser is stored in. Names that already end in a
+// registered extension (e.g. "foo.js") are used as-is; bare names are Python parsers.
+func ParserFileName(parserName string, interpreters map[string]string) string {
+	if ext := filepath.Ext(parserName); ext != "" {
+		if _, ok := interpreters[ext]; ok {
+			return parserName
+		}
+	}
+	return parserName + ".py"
+}
+
+// parserNamePattern is the allowlist for parser names, excluding their extension
+var parserNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidParserName reports whether parserName is safe to use as a file name in the
+// parsers directory: alphanumerics, dashes, and underscores, optionally followed by a
+// registered extension. This rejects path separators, "..", and hidden files.
+func ValidParserName(parserName string, interpreters map[string]string) bool {
+	if ext := filepath.Ext(parserName); ext != "" {
+		if _, ok := interpreters[ext]; ok {
+			parserName = strings.TrimSuffix(parserName, ext)
+		}
+	}
+	return parserNamePattern.MatchString(parserName)
+}
+
+// ExecuteParser runs a parser file with the interpreter registered for its extension
+// and returns the lecture info it outputs as JSON lines.
+// The parser is killed along with any children it spawned if ctx is cancelled
+// or it runs longer than config.ParserTimeout (0 disables the timeout). Invalid
+// lines are skipped and counted, or fail the whole run in strict mode.
+func ExecuteParser(ctx context.Context, parserFile string, config *Config) (*ParserResult, error) {
+	parserPath, err := filepath.Abs(filepath.Join(config.ParsersDir, parserFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve parser path: %w", err)
+	}
+
+	interpreter := strings.Fields(config.Interpreters[filepath.Ext(parserFile)])
+	if len(interpreter) == 0 {
+		return nil, fmt.Errorf("no interpreter registered for %q", filepath.Ext(parserFile))
+	}
+	timeout := config.ParserTimeout
+	strict := config.StrictParserOutput
+
+	slog.Info("Executing parser", "parser_name", parserFile)
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Run the script
+	args := append(interpreter[1:], parserPath)
+	cmd := exec.CommandContext(ctx, interpreter[0], args...)
+
+	// Optionally restrict memory, CPU time, environment, and working directory
+	if config.Sandbox.Enabled {
+		cleanup, err := sandboxCommand(cmd, &config.Sandbox)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+	}
+
+	// Put the parser in its own process group so that children it spawns
+	// (headless browsers, etc.) are killed together with it. Set on top of any
+	// attributes the sandbox configured rather than replacing them.
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	// Keep the tail of stderr so failures can say why the parser crashed
+	stderr := newTailBuffer(stderrTailLines, stderrTailBytes)
+	cmd.Stderr = stderr
 
 	// Capture stdout
 	stdout, err := cmd.StdoutPipe()
@@ -39,30 +165,127 @@ func ExecuteParser(parserName, parsersDir string) ([]LectureInfo, error) {
 	}
 
 	// Read JSON lines from stdout
-	var lectures []LectureInfo
+	result := &ParserResult{}
+	var rejectErr error
 	scanner := bufio.NewScanner(stdout)
 	for scanner.Scan() {
-		line := scanner.Text()
-		if line != "" {
-			var lecture LectureInfo
-			if err := json.Unmarshal([]byte(line), &lecture); err != nil {
-				log.Printf("    Warning: failed to parse JSON: %s", line)
-				continue
-			}
-			lectures = append(lectures, lecture)
-			log.Printf("    Found: %s - %s", lecture.LectureTitle, lecture.URL)
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var lecture LectureInfo
+		if err := json.Unmarshal([]byte(line), &lecture); err != nil {
+			slog.Warn("Failed to parse parser output as JSON", "parser_name", parserFile, "line", line)
+			result.Rejected++
+			rejectErr = fmt.Errorf("invalid JSON line: %s", line)
+		} else if lecture.IsEmpty() {
+			// null and {} unmarshal cleanly into a zero-value lecture
+			slog.Warn("Skipping empty lecture", "parser_name", parserFile, "line", line)
+			continue
+		} else if err := lecture.Validate(); err != nil {
+			slog.Warn("Rejecting lecture", "parser_name", parserFile, "line", line, "error", err)
+			result.Rejected++
+			rejectErr = fmt.Errorf("invalid lecture (%v): %s", err, line)
+		} else {
+			result.Lectures = append(result.Lectures, lecture)
+			slog.Info("Found lecture", "parser_name", parserFile, "class_name", lecture.ClassName,
+				"lecture_title", lecture.LectureTitle, "url", lecture.URL)
+			continue
+		}
+
+		if strict {
+			killProcessGroup(cmd)
+			cmd.Wait()
+			return nil, fmt.Errorf("strict mode: %w", rejectErr)
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
+		killProcessGroup(cmd)
+		cmd.Wait()
 		return nil, fmt.Errorf("error reading parser output: %w", err)
 	}
 
 	// Wait for the command to finish
 	if err := cmd.Wait(); err != nil {
-		return nil, fmt.Errorf("parser execution failed: %w", err)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %v (%v)%s", ErrParserTimeout, timeout, err, stderr.Suffix())
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("parser cancelled: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("parser execution failed: %w%s", err, stderr.Suffix())
+	}
+
+	slog.Info("Parser completed",
+		"parser_name", parserFile, "lecture_count", len(result.Lectures), "rejected_count", result.Rejected)
+	return result, nil
+}
+
+const (
+	stderrTailLines = 20        // stderr lines included in a parser failure error
+	stderrTailBytes = 64 * 1024 // cap on buffered stderr, so chatty parsers can't exhaust memory
+)
+
+// tailBuffer is an io.Writer that keeps only the last maxLines lines (and at most
+// maxBytes bytes) written to it
+type tailBuffer struct {
+	mu       sync.Mutex
+	buf      []byte
+	maxLines int
+	maxBytes int
+}
+
+func newTailBuffer(maxLines, maxBytes int) *tailBuffer {
+	return &tailBuffer{maxLines: maxLines, maxBytes: maxBytes}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.maxBytes {
+		t.buf = t.buf[len(t.buf)-t.maxBytes:]
+	}
+	return len(p), nil
+}
+
+// Lines returns the last maxLines non-empty lines
+func (t *tailBuffer) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var lines []string
+	for _, line := range strings.Split(string(t.buf), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
+	}
+	if len(lines) > t.maxLines {
+		lines = lines[len(lines)-t.maxLines:]
+	}
+	return lines
+}
+
+// Suffix formats the captured stderr for appending to an error message
+func (t *tailBuffer) Suffix() string {
+	lines := t.Lines()
+	if len(lines) == 0 {
+		return ""
 	}
+	return "\n    stderr:\n      " + strings.Join(lines, "\n      ")
+}
 
-	log.Printf("  Completed %s - found %d lecture(s)", parserName, len(lectures))
-	return lectures, nil
+// killProcessGroup sends SIGKILL to every process in the parser's process group
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil // group already gone
+	}
+	return err
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeParser writes a bash parser into dir and returns its file name
+func writeParser(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// processGone reports whether pid has exited. A killed orphan may linger as a zombie
+// until init reaps it, which counts as gone.
+func processGone(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	// The state follows the parenthesised command name
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func TestExecuteParserTimeoutKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads process state from /proc")
+	}
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+	parser := writeParser(t, dir, "spawner.sh", "sleep 60 &\necho $! > "+pidFile+"\nwait\n")
+	config := &Config{ParsersDir: dir, Interpreters: DefaultInterpreters, ParserTimeout: 500 * time.Millisecond}
+
+	start := time.Now()
+	_, err := ExecuteParser(context.Background(), parser, config)
+	if !errors.Is(err, ErrParserTimeout) {
+		t.Fatalf("err = %v, want ErrParserTimeout", err)
+	}
+	// A surviving child would hold stdout open until WaitDelay
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("ExecuteParser took %v to return after the timeout", elapsed)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); !processGone(pid); {
+		if time.Now().After(deadline) {
+			t.Fatalf("child process %d still running after the parser timed out", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestExecuteParserSkipsEmptyLectures(t *testing.T) {
+	dir := t.TempDir()
+	parser := writeParser(t, dir, "empty.sh", `echo null
+echo '{}'
+echo '   '
+echo
+echo '{"class_name":"CS 537","professor":"P","semester":"S","url":"https://example.com/1"}'
+`)
+	config := &Config{ParsersDir: dir, Interpreters: DefaultInterpreters}
+
+	result, err := ExecuteParser(context.Background(), parser, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Lectures) != 1 || result.Lectures[0].URL != "https://example.com/1" {
+		t.Errorf("lectures = %+v, want only the valid one", result.Lectures)
+	}
+	if result.Rejected != 0 {
+		t.Errorf("Rejected = %d, want empty lines skipped rather than rejected", result.Rejected)
+	}
+}
+
+func TestExecuteParserStrictIgnoresEmptyLectures(t *testing.T) {
+	dir := t.TempDir()
+	parser := writeParser(t, dir, "empty.sh", "echo null\necho '{}'\necho '   '\n")
+	config := &Config{ParsersDir: dir, Interpreters: DefaultInterpreters, StrictParserOutput: true}
+
+	result, err := ExecuteParser(context.Background(), parser, config)
+	if err != nil {
+		t.Fatalf("strict mode failed on empty lines: %v", err)
+	}
+	if len(result.Lectures) != 0 {
+		t.Errorf("lectures = %+v, want none", result.Lectures)
+	}
+}
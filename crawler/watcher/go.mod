@@ -3,14 +3,15 @@ module watcher
 go 1.21
 
 require (
-	github.com/gocql/gocql v1.6.0
+	github.com/apache/cassandra-gocql-driver/v2 v2.0.0
 	github.com/redis/go-redis/v9 v9.17.1
+	piazza-bot/shared v0.0.0
 )
 
 require (
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/golang/snappy v0.0.4 // indirect
-	github.com/hailocab/go-hostpool v0.0.0-20160125115350-e80d13ce29ed // indirect
 	gopkg.in/inf.v0 v0.9.1 // indirect
 )
+
+replace piazza-bot/shared => ../../shared
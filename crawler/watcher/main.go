@@ -1,42 +1,88 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"os"
-	"strings"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
-	"github.com/gocql/gocql"
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
+
+	"piazza-bot/shared/health"
+	"piazza-bot/shared/logging"
 )
 
 func main() {
+	// Before loading configuration, so its warnings use the configured format
+	logging.Setup(os.Stderr)
+
 	// Load configuration
 	config := LoadConfig()
 
 	// Connect to Cassandra
 	session, err := ConnectCassandra(config)
 	if err != nil {
-		log.Fatalf("Failed to connect to Cassandra: %v", err)
+		logging.Fatal("Failed to connect to Cassandra", err)
 	}
 	defer session.Close()
-	log.Println("Connected to Cassandra")
+	slog.Info("Connected to Cassandra")
 
 	// Connect to Redis
 	redisClient, err := ConnectRedis(config)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		logging.Fatal("Failed to connect to Redis", err)
 	}
 	defer redisClient.Close()
-	log.Println("Connected to Redis")
-	log.Println()
+	slog.Info("Connected to Redis")
+
+	// Liveness/readiness probes
+	health := health.New(config.LivenessTimeout, "cassandra", "redis")
+	if config.HealthAddr != "" {
+		slog.Info("Serving health probes", "addr", config.HealthAddr)
+		health.Serve(config.HealthAddr)
+	}
+
+	// Encrypts Piazza passwords before they're stored, nil if no key is configured
+	passwords, err := NewPasswordCipher(config.PiazzaEncryptionKey)
+	if err != nil {
+		logging.Fatal("Invalid PIAZZA_ENCRYPTION_KEY", err)
+	}
+	if passwords == nil {
+		slog.Warn("PIAZZA_ENCRYPTION_KEY not set, Piazza passwords are stored in plaintext")
+	}
+
+	// Remembers parser code/output between cycles to skip unchanged parsers
+	tracker := NewParserTracker(config.ParserRerunInterval, config.ForceParserRun)
+
+	// The first SIGINT/SIGTERM lets the current cycle finish, then exits so the deferred
+	// closes run. If the cycle is still running after ShutdownTimeout, its Cassandra
+	// queries and parsers are cancelled. A second signal forces exit.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopping := make(chan struct{})
+	sigchan := make(chan os.Signal, 2)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigchan
+		slog.Info("Caught signal, finishing current cycle before shutting down", "signal", sig)
+		close(stopping)
+		time.AfterFunc(config.ShutdownTimeout, cancel)
+
+		sig = <-sigchan
+		slog.Info("Caught signal again, forcing exit", "signal", sig)
+		os.Exit(1)
+	}()
 
 	// Main polling loop uses a greedy strategy
-	for {
+	for !stopRequested(stopping) {
 		cycleStart := time.Now()
+		health.Tick()
 
-		// Run both functions
-		updateParsers(session, config.ParsersDir)
-		runParsers(config.ParsersDir, redisClient)
+		runCycle(ctx, config, session, passwords, redisClient, tracker, health)
 
 		// Calculate elapsed time
 		elapsed := time.Since(cycleStart)
@@ -45,113 +91,220 @@ func main() {
 		// Otherwise start immediately again.
 		if elapsed < config.PollInterval {
 			remaining := config.PollInterval - elapsed
-			log.Printf("Sleeping for %v until next cycle\n", remaining)
-			time.Sleep(remaining)
+			slog.Info("Sleeping until next cycle", "remaining", remaining)
+			select {
+			case <-time.After(remaining):
+			case <-stopping:
+			}
 		} else {
-			log.Printf("Cycle took longer than poll interval, running immediately\n")
+			slog.Info("Cycle took longer than poll interval, running immediately", "elapsed", elapsed)
 		}
 	}
+	slog.Info("Shutting down")
 }
 
-func updateParsers(session *gocql.Session, parsersDir string) {
-	log.Printf("[%s] Polling Cassandra for parsers...", time.Now().Format("2006-01-02 15:04:05"))
+// stopRequested reports whether stopping has been closed, without blocking
+func stopRequested(stopping <-chan struct{}) bool {
+	select {
+	case <-stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// runCycle updates and runs parsers once, cancelling the cycle if it exceeds CycleTimeout
+// or ctx is cancelled
+func runCycle(ctx context.Context, config *Config, session *gocql.Session, passwords *PasswordCipher,
+	redisClient *RedisClient, tracker *ParserTracker, health *health.Health) {
+	runWithDeadline(ctx, config.CycleTimeout, func(ctx context.Context) {
+		updateParsers(ctx, session, passwords, config, tracker, health)
+		runParsers(ctx, config, redisClient, tracker, health)
+	})
+}
+
+// runWithDeadline runs cycle with a context that is cancelled after timeout (0 disables
+// the deadline), and reports whether the deadline was exceeded
+func runWithDeadline(ctx context.Context, timeout time.Duration, cycle func(ctx context.Context)) bool {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	parsers, err := FetchParsers(session)
+	cycle(ctx)
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		slog.Warn("Cycle exceeded its deadline and was cancelled", "deadline", timeout)
+		return true
+	}
+	return false
+}
+
+func updateParsers(ctx context.Context, session *gocql.Session, passwords *PasswordCipher, config *Config,
+	tracker *ParserTracker, health *health.Health) {
+	slog.Info("Polling Cassandra for parsers")
+
+	parsers, err := FetchParsers(ctx, session, config.CassandraFetchAttempts, config.CassandraFetchBackoff)
 	if err != nil {
-		log.Printf("Error fetching parsers: %v", err)
+		// Never clean up without a real parser list: that would delete every parser on disk
+		slog.Error("Error fetching parsers, skipping cleanup and writes", "error", err)
+		if ctx.Err() == nil {
+			health.MarkFailed("cassandra")
+		}
 		return
 	}
+	health.MarkOK("cassandra")
 
-	log.Printf("Found %d parser(s) in Cassandra", len(parsers))
+	slog.Info("Fetched parsers from Cassandra", "parser_count", len(parsers))
 
 	// Clean up parsers that were deleted from Cassandra
-	if err := CleanupDeletedParsers(parsers, parsersDir, session); err != nil {
-		log.Printf("Error cleaning up deleted parsers: %v", err)
+	if err := CleanupDeletedParsers(parsers, config.ParsersDir, config.Interpreters, session, tracker, config.AllowEmptyCleanup); err != nil {
+		slog.Error("Error cleaning up deleted parsers", "error", err)
 	}
 
 	// Write current parsers to disk and upsert Piazza configs
 	if len(parsers) > 0 {
-		if err := WriteParsersToDisk(parsers, parsersDir); err != nil {
-			log.Printf("Error writing parsers to disk: %v", err)
+		if err := WriteParsersToDisk(parsers, config.ParsersDir, config.Interpreters); err != nil {
+			slog.Error("Error writing parsers to disk", "error", err)
 			return
 		}
 
-		log.Println("Parsers written to disk:")
 		for _, p := range parsers {
-			log.Printf("  - %s", p.ParserName)
-
 			// Try to extract and upsert Piazza config
-			config, err := ExtractPiazzaConfig(p.CodeText)
-			if err != nil {
+			piazzaConfig, err := ExtractPiazzaConfig(p.CodeText)
+			switch {
+			case errors.Is(err, ErrNoPiazzaConfig):
 				// Not an error - parser might not have Piazza config
-				log.Printf("No Piazza config found (skipping)")
-			} else {
-				if err := UpsertPiazzaConfig(session, config); err != nil {
-					log.Printf("Error upserting Piazza config: %v", err)
+				slog.Info("No Piazza config found (skipping)", "parser_name", p.ParserName)
+			case err != nil:
+				// Incomplete header: skip this parser's config, keep the cycle going
+				slog.Warn("Invalid Piazza config (skipping)", "parser_name", p.ParserName, "error", err)
+			default:
+				if err := UpsertPiazzaConfig(session, piazzaConfig, passwords); err != nil {
+					slog.Error("Error upserting Piazza config", "parser_name", p.ParserName, "error", err)
 				} else {
-					log.Printf("Piazza config upserted (network: %s)", config.NetworkID)
+					slog.Info("Piazza config upserted", "parser_name", p.ParserName, "network_id", piazzaConfig.NetworkID)
 				}
 			}
 		}
 	}
 }
 
-func runParsers(parsersDir string, redisClient *RedisClient) {
-	log.Printf("[%s] Running parsers...", time.Now().Format("2006-01-02 15:04:05"))
+func runParsers(ctx context.Context, config *Config, redisClient *RedisClient, tracker *ParserTracker, health *health.Health) {
+	parsersDir := config.ParsersDir
+
+	slog.Info("Running parsers")
 
 	// Get list of parser files
 	entries, err := os.ReadDir(parsersDir)
 	if err != nil {
-		log.Printf("Error reading parsers directory: %v", err)
+		slog.Error("Error reading parsers directory", "error", err)
 		return
 	}
 
-	// Filter for .py files
+	// Filter for files with a registered interpreter
 	var parserNames []string
 	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".py") {
-			parserName := strings.TrimSuffix(entry.Name(), ".py")
-			parserNames = append(parserNames, parserName)
+		if _, ok := config.Interpreters[filepath.Ext(entry.Name())]; ok && !entry.IsDir() &&
+			!isTempFile(entry.Name()) {
+			parserNames = append(parserNames, entry.Name())
 		}
 	}
 
 	if len(parserNames) == 0 {
-		log.Println("No parsers to run")
-		log.Println()
+		slog.Info("No parsers to run")
 		return
 	}
 
-	log.Printf("Found %d parser(s) to execute\n", len(parserNames))
+	slog.Info("Found parsers to execute", "parser_count", len(parserNames))
 
 	// Track statistics
 	totalLectures := 0
 	newLectures := 0
+	rejectedLines := 0
+	skipped := 0
+
+	// Set once Redis is unreachable or the cycle is cancelled, every later lecture would fail too
+	queueDown := false
 
 	for _, parserName := range parserNames {
-		lectures, err := ExecuteParser(parserName, parsersDir)
+		if ctx.Err() != nil {
+			slog.Warn("Cycle cancelled, skipping remaining parsers")
+			break
+		}
+		if queueDown {
+			slog.Warn("Redis unavailable, skipping remaining parsers")
+			break
+		}
+
+		code, err := os.ReadFile(filepath.Join(parsersDir, parserName))
 		if err != nil {
-			log.Printf("  Error executing %s: %v", parserName, err)
+			slog.Error("Error reading parser", "parser_name", parserName, "error", err)
+			continue
+		}
+		codeHash := hashContent(code)
+		if !tracker.ShouldRun(parserName, codeHash, time.Now()) {
+			skipped++
 			continue
 		}
 
-		log.Printf("  %s returned %d lecture(s)", parserName, len(lectures))
-		totalLectures += len(lectures)
+		result, err := ExecuteParser(ctx, parserName, config)
+		if errors.Is(err, ErrParserTimeout) {
+			slog.Warn("Parser timed out, moving on to the next parser",
+				"parser_name", parserName, "timeout", config.ParserTimeout)
+			continue
+		}
+		if err != nil {
+			slog.Error("Error executing parser", "parser_name", parserName, "error", err)
+			continue
+		}
+
+		tracker.Record(parserName, codeHash, result.Lectures, time.Now())
+
+		totalLectures += len(result.Lectures)
+		rejectedLines += result.Rejected
 
 		// Add each lecture to Redis queue
-		for _, lecture := range lectures {
-			added, err := redisClient.AddLecture(lecture)
+		for _, lecture := range result.Lectures {
+			var added bool
+			if priority := config.ClassPriorities[lecture.ClassName]; priority > 0 {
+				added, err = redisClient.AddLectureWithPriority(ctx, lecture, priority)
+			} else {
+				added, err = redisClient.AddLecture(ctx, lecture)
+			}
 			if err != nil {
-				log.Printf("    Error adding lecture to Redis: %v", err)
+				slog.Error("Error adding lecture to Redis",
+					"parser_name", parserName, "class_name", lecture.ClassName, "url", lecture.URL, "error", err)
+				// Only connectivity errors make the watcher unready, not e.g. a lecture that
+				// fails to marshal
+				if isRedisConnectionError(err) {
+					health.MarkFailed("redis")
+				}
+				if isRedisConnectionError(err) || ctx.Err() != nil {
+					queueDown = true
+					break
+				}
 				continue
 			}
+			health.MarkOK("redis")
 			if added {
 				newLectures++
-				log.Printf("    Queued: %s", lecture.URL)
+				slog.Info("Queued lecture",
+					"parser_name", parserName, "class_name", lecture.ClassName, "url", lecture.URL)
 			} else {
-				log.Printf("    Skipped (already seen): %s", lecture.URL)
+				slog.Info("Skipped lecture (already seen)",
+					"parser_name", parserName, "class_name", lecture.ClassName, "url", lecture.URL)
 			}
 		}
+
+		// Its lectures weren't all queued, so run the parser again next cycle even if unchanged
+		if queueDown {
+			tracker.Forget(parserName)
+		}
 	}
 
-	log.Printf("\nSummary: %d total lectures, %d new, %d already seen\n", totalLectures, newLectures, totalLectures-newLectures)
+	slog.Info("Cycle summary",
+		"lecture_count", totalLectures, "new_count", newLectures, "seen_count", totalLectures-newLectures,
+		"rejected_count", rejectedLines, "skipped_count", skipped)
 }
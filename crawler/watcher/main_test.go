@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"piazza-bot/shared/health"
+)
+
+func TestCycleExceedingDeadlineIsCancelled(t *testing.T) {
+	dir := t.TempDir()
+	writeParser(t, dir, "hang.sh", "sleep 60\n")
+	writeParser(t, dir, "later.sh", "echo never reached\n")
+	config := &Config{ParsersDir: dir, Interpreters: DefaultInterpreters}
+	tracker := NewParserTracker(0, false)
+	h := health.New(0, "redis")
+
+	// The first parser hangs with no parser timeout, so only the cycle deadline stops it
+	start := time.Now()
+	exceeded := runWithDeadline(context.Background(), 300*time.Millisecond, func(ctx context.Context) {
+		runParsers(ctx, config, nil, tracker, h)
+	})
+	if !exceeded {
+		t.Error("runWithDeadline did not report the exceeded deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("cycle took %v, want it cancelled at the deadline", elapsed)
+	}
+	if _, ok := tracker.runs["later.sh"]; ok {
+		t.Error("parsers after the deadline still ran")
+	}
+
+	// The next cycle gets a fresh deadline and runs to completion
+	ran := false
+	exceeded = runWithDeadline(context.Background(), time.Second, func(ctx context.Context) {
+		ran = ctx.Err() == nil
+	})
+	if exceeded || !ran {
+		t.Errorf("next cycle: exceeded=%v ran=%v, want it to run normally", exceeded, ran)
+	}
+}
+
+func TestCycleWithoutDeadline(t *testing.T) {
+	exceeded := runWithDeadline(context.Background(), 0, func(ctx context.Context) {
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("a zero timeout should not set a deadline")
+		}
+	})
+	if exceeded {
+		t.Error("exceeded = true without a deadline")
+	}
+}
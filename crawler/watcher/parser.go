@@ -2,44 +2,112 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/gocql/gocql"
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
 )
 
 // WriteParsersToDisk writes parser code to the parsers directory
-func WriteParsersToDisk(parsers []Parser, parsersDir string) error {
+func WriteParsersToDisk(parsers []Parser, parsersDir string, interpreters map[string]string) error {
 	// Create parsers directory if it doesn't exist
 	if err := os.MkdirAll(parsersDir, 0755); err != nil {
 		return fmt.Errorf("failed to create parsers directory: %w", err)
 	}
 
 	for _, parser := range parsers {
-		filename := filepath.Join(parsersDir, parser.ParserName+".py")
+		if !ValidParserName(parser.ParserName, interpreters) {
+			slog.Warn("Skipping parser with unsafe name", "parser_name", parser.ParserName)
+			continue
+		}
+
+		filename := filepath.Join(parsersDir, ParserFileName(parser.ParserName, interpreters))
+
+		// Skip the rewrite if the file on disk already has this code, so its mtime
+		// only changes when the code does
+		if existing, err := os.ReadFile(filename); err == nil && string(existing) == parser.CodeText {
+			continue
+		}
 
-		if err := os.WriteFile(filename, []byte(parser.CodeText), 0644); err != nil {
-			log.Printf("Error writing parser %s: %v", parser.ParserName, err)
+		if err := writeFileAtomic(filename, []byte(parser.CodeText), 0644); err != nil {
+			slog.Error("Error writing parser", "parser_name", parser.ParserName, "error", err)
 			continue
 		}
 
-		log.Printf("  Wrote %s", filename)
+		slog.Info("Wrote parser", "parser_name", parser.ParserName, "path", filename)
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes data to a temp file in the same directory and renames it over
+// filename, so a parser being read or executed is never seen half-written, even if the
+// watcher crashes mid-write
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*"+tempFileSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	// Clean up the temp file on any failure
+	success := false
+	defer func() {
+		if !success {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Rename(tmpName, filename); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
 	}
 
+	success = true
 	return nil
 }
 
-// CleanupDeletedParsers removes parser files and their Piazza configs that are no longer in Cassandra
-func CleanupDeletedParsers(parsers []Parser, parsersDir string, session *gocql.Session) error {
-	// Build a set of valid parser names from Cassandra
+// tempFileSuffix ends the names of writeFileAtomic's temp files
+const tempFileSuffix = ".tmp"
+
+// isTempFile reports whether a file in the parsers directory is an in-progress write,
+// or any other hidden file, rather than a parser
+func isTempFile(filename string) bool {
+	return strings.HasPrefix(filename, ".") || strings.HasSuffix(filename, tempFileSuffix)
+}
+
+// CleanupDeletedParsers removes parser files and their Piazza configs that are no longer in Cassandra.
+// An empty parsers list would delete every parser on disk, which is far more likely to be
+// a bad fetch than a real deletion, so that is refused with a loud error unless allowEmpty is set.
+// Deleted parsers are dropped from tracker, so one re-added under the same name always runs.
+func CleanupDeletedParsers(parsers []Parser, parsersDir string, interpreters map[string]string,
+	session *gocql.Session, tracker *ParserTracker, allowEmpty bool) error {
+	// Build a set of valid parser file names from Cassandra
 	validParsers := make(map[string]bool)
 	for _, parser := range parsers {
-		validParsers[parser.ParserName] = true
+		if !ValidParserName(parser.ParserName, interpreters) {
+			slog.Warn("Ignoring parser with unsafe name", "parser_name", parser.ParserName)
+			continue
+		}
+		validParsers[ParserFileName(parser.ParserName, interpreters)] = true
 	}
 
-	// Read all .py files in parsers directory
+	// Read all parser files in parsers directory
 	entries, err := os.ReadDir(parsersDir)
 	if err != nil {
 		// If directory doesn't exist, nothing to clean up
@@ -49,44 +117,53 @@ func CleanupDeletedParsers(parsers []Parser, parsersDir string, session *gocql.S
 		return fmt.Errorf("failed to read parsers directory: %w", err)
 	}
 
-	// Check each .py file
+	// Collect parser files that are no longer in Cassandra
+	var deleted []string
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
 
 		filename := entry.Name()
-		if !strings.HasSuffix(filename, ".py") {
+		if _, ok := interpreters[filepath.Ext(filename)]; !ok || isTempFile(filename) {
 			continue
 		}
+		if !validParsers[filename] {
+			deleted = append(deleted, filename)
+		}
+	}
 
-		// Extract parser name (remove .py extension)
-		parserName := strings.TrimSuffix(filename, ".py")
+	if len(parsers) == 0 && len(deleted) > 0 && !allowEmpty {
+		slog.Error("REFUSING to delete every parser on disk: Cassandra returned no parsers. "+
+			"Set ALLOW_EMPTY_PARSER_CLEANUP=true if they really were all removed.",
+			"parser_count", len(deleted))
+		return nil
+	}
 
-		// If this parser is not in Cassandra, delete it and its Piazza config
-		if !validParsers[parserName] {
-			filePath := filepath.Join(parsersDir, filename)
+	// Delete each parser and its Piazza config
+	for _, filename := range deleted {
+		filePath := filepath.Join(parsersDir, filename)
 
-			// First, try to extract Piazza config to get network_id before deleting
-			codeBytes, err := os.ReadFile(filePath)
+		// First, try to extract Piazza config to get network_id before deleting
+		codeBytes, err := os.ReadFile(filePath)
+		if err == nil {
+			config, err := ExtractPiazzaConfig(string(codeBytes))
 			if err == nil {
-				config, err := ExtractPiazzaConfig(string(codeBytes))
-				if err == nil {
-					// Delete the Piazza config from Cassandra
-					if err := DeletePiazzaConfig(session, config.NetworkID); err != nil {
-						log.Printf("  Error deleting Piazza config for %s: %v", filename, err)
-					} else {
-						log.Printf("  Deleted Piazza config (network: %s)", config.NetworkID)
-					}
+				// Delete the Piazza config from Cassandra
+				if err := DeletePiazzaConfig(session, config.NetworkID); err != nil {
+					slog.Error("Error deleting Piazza config", "parser_name", filename, "error", err)
+				} else {
+					slog.Info("Deleted Piazza config", "parser_name", filename, "network_id", config.NetworkID)
 				}
 			}
+		}
 
-			// Delete the parser file
-			if err := os.Remove(filePath); err != nil {
-				log.Printf("Error deleting parser %s: %v", filename, err)
-			} else {
-				log.Printf("  Deleted %s (no longer in Cassandra)", filename)
-			}
+		// Delete the parser file
+		if err := os.Remove(filePath); err != nil {
+			slog.Error("Error deleting parser", "parser_name", filename, "error", err)
+		} else {
+			tracker.Forget(filename)
+			slog.Info("Deleted parser no longer in Cassandra", "parser_name", filename)
 		}
 	}
 
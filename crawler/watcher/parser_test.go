@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomicNeverExposesPartialFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "parser.py")
+	versions := [][]byte{bytes.Repeat([]byte("a"), 1<<20), bytes.Repeat([]byte("b"), 1<<20)}
+	if err := writeFileAtomic(filename, versions[0], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep rewriting the file while reading it; every read must see one whole version
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if err := writeFileAtomic(filename, versions[i%2], 0644); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, versions[0]) && !bytes.Equal(data, versions[1]) {
+			t.Fatalf("read a partially written file (%d bytes)", len(data))
+		}
+	}
+	wg.Wait()
+}
+
+func TestWriteFileAtomicFailureLeavesNoTempFile(t *testing.T) {
+	dir := t.TempDir()
+	// The rename fails because a non-empty directory is in the way
+	filename := filepath.Join(dir, "parser.py")
+	if err := os.MkdirAll(filepath.Join(filename, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(filename, []byte("print('hi')\n"), 0644); err == nil {
+		t.Fatal("want an error when the rename fails")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the directory in the way should remain
+	for _, entry := range entries {
+		if entry.Name() != "parser.py" {
+			t.Errorf("temp file %s left behind after a failed write", entry.Name())
+		}
+	}
+	if info, err := os.Stat(filename); err != nil || !info.IsDir() {
+		t.Errorf("destination was replaced by a failed write")
+	}
+}
+
+func TestCleanupDeletedParsersRemovesParsersMissingFromCassandra(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"keep.py":                       "print('keep')\n",
+		"gone.py":                       "print('gone')\n", // no Piazza header, so the session is never used
+		"notes.txt":                     "not a parser\n",
+		".gone.py.123" + tempFileSuffix: "in-progress write\n",
+	}
+	for name, code := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracker := NewParserTracker(time.Hour, false)
+	now := time.Now()
+	tracker.Record("gone.py", hashContent([]byte(files["gone.py"])), nil, now)
+	tracker.Record("gone.py", hashContent([]byte(files["gone.py"])), nil, now)
+
+	parsers := []Parser{{ParserName: "keep", CodeText: files["keep.py"]}}
+	if err := CleanupDeletedParsers(parsers, dir, DefaultInterpreters, nil, tracker, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if gone := os.IsNotExist(err); gone != (name == "gone.py") {
+			t.Errorf("%s: removed = %v", name, gone)
+		}
+	}
+	if !tracker.ShouldRun("gone.py", hashContent([]byte(files["gone.py"])), now) {
+		t.Error("deleted parser is still tracked, so a re-added one would be skipped")
+	}
+}
+
+func TestCleanupDeletedParsersRefusesEmptyList(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "keep.py")
+	if err := os.WriteFile(filename, []byte("print('keep')\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CleanupDeletedParsers(nil, dir, DefaultInterpreters, nil, NewParserTracker(0, false), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename); err != nil {
+		t.Errorf("parser deleted after an empty fetch: %v", err)
+	}
+}
@@ -2,25 +2,47 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 // RedisClient wraps the Redis client with our configuration
 type RedisClient struct {
-	client  *redis.Client
-	queue   string
-	seenSet string
-	ctx     context.Context
+	client        *redis.Client
+	queue         string
+	priorityQueue string
+	seenSet       string
+	seenTTL       time.Duration // 0 = seen URLs never expire
+
+	options          *redis.Options
+	reconnectTries   int           // reconnect attempts after a connection error
+	reconnectBackoff time.Duration // wait before the first reconnect, doubled each attempt
 }
 
 // ConnectRedis establishes a connection to Redis
 func ConnectRedis(config *Config) (*RedisClient, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr: fmt.Sprintf("%s:%s", config.RedisHost, config.RedisPort),
-	})
+	options := &redis.Options{
+		Addr:     fmt.Sprintf("%s:%s", config.RedisHost, config.RedisPort),
+		Password: config.RedisPassword,
+		DB:       config.RedisDB,
+	}
+	if config.RedisTLS {
+		options.TLSConfig = &tls.Config{
+			ServerName: config.RedisHost,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	client := redis.NewClient(options)
 
 	// Test connection
 	ctx := context.Background()
@@ -28,58 +50,249 @@ func ConnectRedis(config *Config) (*RedisClient, error) {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	return &RedisClient{
-		client:  client,
-		queue:   config.RedisQueue,
-		seenSet: config.RedisSeenSet,
-		ctx:     ctx,
-	}, nil
+	r := &RedisClient{
+		client:        client,
+		queue:         config.RedisQueue,
+		priorityQueue: config.RedisPriorityQueue,
+		seenSet:       config.RedisSeenSet,
+		seenTTL:       config.SeenTTL,
+
+		options:          options,
+		reconnectTries:   config.RedisReconnectAttempts,
+		reconnectBackoff: config.RedisReconnectBackoff,
+	}
+	if err := r.migrateSeen(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return r, nil
 }
 
-// IsSeen checks if a URL has been seen before
-func (r *RedisClient) IsSeen(url string) (bool, error) {
-	result, err := r.client.SIsMember(r.ctx, r.seenSet, url).Result()
-	if err != nil {
-		return false, fmt.Errorf("error checking seen set: %w", err)
+// withReconnect runs op, and if it fails with a connection error replaces the client
+// with a fresh connection (with exponential backoff) and retries op once connected.
+// If the reply to the failed attempt was lost, op may run twice. Gives up as soon as
+// ctx is done, returning the connection error along with ctx's.
+func (r *RedisClient) withReconnect(ctx context.Context, op func() error) error {
+	err := op()
+	if err == nil || !isRedisConnectionError(err) {
+		return err
 	}
-	return result, nil
+
+	backoff := r.reconnectBackoff
+	for attempt := 1; attempt <= r.reconnectTries; attempt++ {
+		slog.Warn("Redis connection error, reconnecting",
+			"backoff", backoff, "attempt", attempt, "attempts", r.reconnectTries, "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("redis reconnect abandoned: %w (%w)", err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+
+		client := redis.NewClient(r.options)
+		if pingErr := client.Ping(ctx).Err(); pingErr != nil {
+			client.Close()
+			err = pingErr
+			continue
+		}
+		r.client.Close()
+		r.client = client
+
+		if err = op(); err == nil || !isRedisConnectionError(err) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("redis unavailable after %d reconnect attempts: %w", r.reconnectTries, err)
 }
 
-// AddLecture adds a lecture to both the seen set (by URL) and the queue (as JSON)
-// Returns true if the lecture was newly added (not seen before)
-func (r *RedisClient) AddLecture(lecture LectureInfo) (bool, error) {
-	// Check if URL already seen
-	seen, err := r.IsSeen(lecture.URL)
+// isRedisConnectionError reports whether err came from the connection rather than a command
+func isRedisConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, redis.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// seenKey returns the key of the seen set. With a TTL the seen URLs live in a sorted set
+// scored by expiry time under a separate key, since a plain set can't expire members.
+func (r *RedisClient) seenKey() string {
+	if r.seenTTL > 0 {
+		return r.seenSet + ":expiring"
+	}
+	return r.seenSet
+}
+
+// migrateSeenScript moves seen URLs between the plain and the expiring seen set when
+// SEEN_TTL is turned on or off, so switching modes doesn't forget them. Plain members get
+// a fresh expiry of now + ttl (an existing expiry is kept); expiring members that haven't
+// expired yet become plain. The old key is deleted.
+// KEYS: plain set, expiring set. ARGV: now (Unix seconds), seen TTL in seconds (0 = plain).
+var migrateSeenScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+local moved = 0
+if ttl > 0 then
+	for _, url in ipairs(redis.call("SMEMBERS", KEYS[1])) do
+		moved = moved + redis.call("ZADD", KEYS[2], "NX", now + ttl, url)
+	end
+	redis.call("DEL", KEYS[1])
+else
+	for _, url in ipairs(redis.call("ZRANGEBYSCORE", KEYS[2], "(" .. now, "+inf")) do
+		moved = moved + redis.call("SADD", KEYS[1], url)
+	end
+	redis.call("DEL", KEYS[2])
+end
+return moved
+`)
+
+// migrateSeen runs migrateSeenScript for the configured SEEN_TTL
+func (r *RedisClient) migrateSeen(ctx context.Context) error {
+	var moved int
+	err := r.withReconnect(ctx, func() (err error) {
+		moved, err = migrateSeenScript.Run(ctx, r.client, []string{r.seenSet, r.seenSet + ":expiring"},
+			time.Now().Unix(), int64(r.seenTTL.Seconds())).Int()
+		return err
+	})
 	if err != nil {
-		return false, err
+		return fmt.Errorf("error migrating seen set: %w", err)
 	}
+	if moved > 0 {
+		slog.Info("Migrated seen URLs to the configured seen set", "count", moved, "seen_key", r.seenKey())
+	}
+	return nil
+}
 
-	if seen {
-		return false, nil
+// IsSeen checks if a URL has been seen before (and, with a TTL, hasn't expired)
+func (r *RedisClient) IsSeen(ctx context.Context, url string) (bool, error) {
+	if r.seenTTL > 0 {
+		var expiry float64
+		err := r.withReconnect(ctx, func() (err error) {
+			expiry, err = r.client.ZScore(ctx, r.seenKey(), url).Result()
+			return err
+		})
+		if err == redis.Nil {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("error checking seen set: %w", err)
+		}
+		return expiry > float64(time.Now().Unix()), nil
 	}
 
-	// Add URL to seen set
-	if err := r.client.SAdd(r.ctx, r.seenSet, lecture.URL).Err(); err != nil {
-		return false, fmt.Errorf("error adding to seen set: %w", err)
+	var result bool
+	err := r.withReconnect(ctx, func() (err error) {
+		result, err = r.client.SIsMember(ctx, r.seenKey(), url).Result()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error checking seen set: %w", err)
 	}
+	return result, nil
+}
 
-	// Marshal lecture to JSON
+// addLectureScript marks the URL seen and enqueues the lecture only if the URL was not
+// already seen, so concurrent callers can't both enqueue it.
+// KEYS: seen, queue. ARGV: url, lecture JSON, now (Unix seconds), seen TTL in seconds
+// (0 = plain set, never expires), sorted-set score ("" = push onto a list).
+var addLectureScript = redis.NewScript(`
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+if ttl > 0 then
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+	if redis.call("ZSCORE", KEYS[1], ARGV[1]) then
+		return 0
+	end
+	redis.call("ZADD", KEYS[1], now + ttl, ARGV[1])
+elseif redis.call("SADD", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+
+if ARGV[5] == "" then
+	redis.call("RPUSH", KEYS[2], ARGV[2])
+else
+	redis.call("ZADD", KEYS[2], ARGV[5], ARGV[2])
+end
+return 1
+`)
+
+// enqueue runs addLectureScript against queue; score "" pushes onto a list
+func (r *RedisClient) enqueue(ctx context.Context, lecture LectureInfo, queue, score string) (bool, error) {
 	jsonData, err := json.Marshal(lecture)
 	if err != nil {
 		return false, fmt.Errorf("failed to marshal lecture to JSON: %w", err)
 	}
 
-	// Add JSON to queue
-	if err := r.client.RPush(r.ctx, r.queue, string(jsonData)).Err(); err != nil {
-		return false, fmt.Errorf("error adding to queue: %w", err)
+	var added int
+	err = r.withReconnect(ctx, func() (err error) {
+		added, err = addLectureScript.Run(ctx, r.client, []string{r.seenKey(), queue},
+			lecture.URL, string(jsonData), time.Now().Unix(), int64(r.seenTTL.Seconds()), score).Int()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error adding lecture: %w", err)
+	}
+
+	return added == 1, nil
+}
+
+// AddLecture adds a lecture to both the seen set (by URL) and the queue (as JSON)
+// Returns true if the lecture was newly added (not seen before, or its seen entry expired)
+func (r *RedisClient) AddLecture(ctx context.Context, lecture LectureInfo) (bool, error) {
+	return r.enqueue(ctx, lecture, r.queue, "")
+}
+
+// priorityTimeScale separates priority levels in a sorted-set score. It exceeds any
+// Unix timestamp in seconds, so the time term never crosses into another priority level.
+const priorityTimeScale = 1e10
+
+// AddLectureWithPriority adds a lecture to the seen set and to the priority queue, a
+// sorted set popped highest score first. The score is priority*1e10 - enqueue time in
+// Unix seconds: a higher priority always wins, and within one priority the lecture
+// queued earliest is popped first. Returns true if the lecture was newly added.
+func (r *RedisClient) AddLectureWithPriority(ctx context.Context, lecture LectureInfo, priority int) (bool, error) {
+	lecture.Priority = priority
+	score := float64(priority)*priorityTimeScale - float64(time.Now().Unix())
+	return r.enqueue(ctx, lecture, r.priorityQueue, strconv.FormatFloat(score, 'f', -1, 64))
+}
+
+// PopHighestPriority removes and returns the highest-priority lecture (see
+// AddLectureWithPriority for scoring), or nil if the priority queue is empty
+func (r *RedisClient) PopHighestPriority(ctx context.Context) (*LectureInfo, error) {
+	var results []redis.Z
+	err := r.withReconnect(ctx, func() (err error) {
+		results, err = r.client.ZPopMax(ctx, r.priorityQueue, 1).Result()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error popping priority queue: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	member, ok := results[0].Member.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected priority queue member type %T", results[0].Member)
 	}
 
-	return true, nil
+	var lecture LectureInfo
+	if err := json.Unmarshal([]byte(member), &lecture); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal lecture: %w", err)
+	}
+	return &lecture, nil
 }
 
 // GetQueueLength returns the current length of the queue
-func (r *RedisClient) GetQueueLength() (int64, error) {
-	length, err := r.client.LLen(r.ctx, r.queue).Result()
+func (r *RedisClient) GetQueueLength(ctx context.Context) (int64, error) {
+	var length int64
+	err := r.withReconnect(ctx, func() (err error) {
+		length, err = r.client.LLen(ctx, r.queue).Result()
+		return err
+	})
 	if err != nil {
 		return 0, fmt.Errorf("error getting queue length: %w", err)
 	}
@@ -87,8 +300,16 @@ func (r *RedisClient) GetQueueLength() (int64, error) {
 }
 
 // GetSeenCount returns the number of URLs in the seen set
-func (r *RedisClient) GetSeenCount() (int64, error) {
-	count, err := r.client.SCard(r.ctx, r.seenSet).Result()
+func (r *RedisClient) GetSeenCount(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.withReconnect(ctx, func() (err error) {
+		if r.seenTTL > 0 {
+			count, err = r.client.ZCount(ctx, r.seenKey(), fmt.Sprintf("(%d", time.Now().Unix()), "+inf").Result()
+		} else {
+			count, err = r.client.SCard(ctx, r.seenKey()).Result()
+		}
+		return err
+	})
 	if err != nil {
 		return 0, fmt.Errorf("error getting seen count: %w", err)
 	}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestWithReconnectStopsBackingOffWhenCancelled(t *testing.T) {
+	r := &RedisClient{
+		options:          &redis.Options{Addr: "127.0.0.1:1"},
+		reconnectTries:   3,
+		reconnectBackoff: time.Hour,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	calls := 0
+	err := r.withReconnect(ctx, func() error {
+		calls++
+		return syscall.ECONNREFUSED
+	})
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("withReconnect returned after %v, want soon after cancellation", elapsed)
+	}
+	if !errors.Is(err, context.Canceled) || !isRedisConnectionError(err) {
+		t.Errorf("err = %v, want the connection error and context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Errorf("op ran %d times, want 1", calls)
+	}
+}
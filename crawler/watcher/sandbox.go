@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultSandboxEnv are the variables passed through to a sandboxed parser
+var defaultSandboxEnv = []string{"PATH", "LANG", "LC_ALL", "TZ"}
+
+// SandboxConfig restricts the resources and environment available to a parser
+type SandboxConfig struct {
+	Enabled        bool
+	MemoryLimitMB  int           // address space cap, 0 = unlimited
+	CPULimit       time.Duration // CPU time cap, 0 = unlimited
+	AllowedEnv     []string      // extra environment variables passed through
+	WorkDirPattern string        // os.MkdirTemp pattern for each run's working directory
+}
+
+// sandboxCommand builds the command for a parser run under the sandbox. The returned
+// cleanup removes the run's working directory.
+//
+// Limits are applied with ulimit in a shell that then execs the interpreter, rather than
+// with setrlimit. SysProcAttr has no rlimit fields and os/exec has no hook that runs in
+// the child before exec; calling Setrlimit in the watcher would limit the watcher itself
+// and every parser it starts, and prlimit on the started pid leaves a window where the
+// parser runs unlimited. The shell sets the limits in the child before the parser's
+// first instruction, and exec keeps the pid, so killing the process group still works.
+func sandboxCommand(cmd *exec.Cmd, sandbox *SandboxConfig) (cleanup func(), err error) {
+	workDir, err := os.MkdirTemp("", sandbox.WorkDirPattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create parser working directory: %w", err)
+	}
+	cleanup = func() { os.RemoveAll(workDir) }
+
+	var limits []string
+	if sandbox.MemoryLimitMB > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -v %d", sandbox.MemoryLimitMB*1024))
+	}
+	if sandbox.CPULimit > 0 {
+		seconds := int(sandbox.CPULimit.Seconds())
+		if seconds < 1 {
+			seconds = 1
+		}
+		limits = append(limits, fmt.Sprintf("ulimit -t %d", seconds))
+	}
+	if len(limits) > 0 {
+		script := strings.Join(limits, " && ") + ` && exec "$@"`
+		cmd.Args = append([]string{"sh", "-c", script, "sh"}, cmd.Args...)
+		cmd.Path, err = exec.LookPath("sh")
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to find sh for parser limits: %w", err)
+		}
+	}
+
+	cmd.Dir = workDir
+	cmd.Env = []string{"HOME=" + workDir, "TMPDIR=" + workDir}
+	for _, name := range append(defaultSandboxEnv, sandbox.AllowedEnv...) {
+		if value, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
+
+	return cleanup, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// ParserTracker remembers each parser's code and output across cycles so parsers that
+// haven't changed aren't re-executed every cycle
+type ParserTracker struct {
+	runs          map[string]*parserRun
+	rerunInterval time.Duration
+	force         bool
+}
+
+// parserRun is the state recorded after a parser's last execution
+type parserRun struct {
+	codeHash   string
+	outputHash string
+	stable     bool // output matched the run before it
+	lastRun    time.Time
+}
+
+// NewParserTracker returns a tracker that still re-runs an unchanged parser once
+// rerunInterval has passed. force disables skipping entirely.
+func NewParserTracker(rerunInterval time.Duration, force bool) *ParserTracker {
+	return &ParserTracker{
+		runs:          make(map[string]*parserRun),
+		rerunInterval: rerunInterval,
+		force:         force,
+	}
+}
+
+// ShouldRun reports whether the parser needs executing: its code changed, its last
+// output differed from the one before, or rerunInterval has passed since it last ran
+func (t *ParserTracker) ShouldRun(parserName, codeHash string, now time.Time) bool {
+	if t.force {
+		return true
+	}
+	run, ok := t.runs[parserName]
+	if !ok || run.codeHash != codeHash || !run.stable {
+		return true
+	}
+	return now.Sub(run.lastRun) >= t.rerunInterval
+}
+
+// Record stores the result of a successful parser execution
+func (t *ParserTracker) Record(parserName, codeHash string, lectures []LectureInfo, now time.Time) {
+	outputHash := hashLectures(lectures)
+	run, ok := t.runs[parserName]
+	stable := ok && run.codeHash == codeHash && run.outputHash == outputHash
+	t.runs[parserName] = &parserRun{
+		codeHash:   codeHash,
+		outputHash: outputHash,
+		stable:     stable,
+		lastRun:    now,
+	}
+}
+
+// Forget drops a parser's state so its next cycle always runs it
+func (t *ParserTracker) Forget(parserName string) {
+	delete(t.runs, parserName)
+}
+
+// hashContent returns the hex SHA-256 of data
+func hashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashLectures hashes a parser's output
+func hashLectures(lectures []LectureInfo) string {
+	data, _ := json.Marshal(lectures)
+	return hashContent(data)
+}
@@ -13,6 +13,8 @@ CASSANDRA_HOST = os.getenv('CASSANDRA_HOST', 'localhost')
 CASSANDRA_PORT = int(os.getenv('CASSANDRA_PORT', 9042))
 CASSANDRA_KEYSPACE = os.getenv('CASSANDRA_KEYSPACE', 'transcript_db')
 PARSERS_DIR = Path(__file__).parent / 'parsers'
+# Must match the watcher's interpreter map (PARSER_INTERPRETERS)
+PARSER_EXTENSIONS = {'.py', '.js', '.sh'}
 
 
 def get_cassandra_session():
@@ -30,13 +32,21 @@ def get_cassandra_session():
         sys.exit(1)
 
 
+def parser_name_for(file_path):
+    """
+    Python parsers are stored by bare name; other languages keep their extension
+    so the watcher knows which interpreter to run them with
+    """
+    return file_path.stem if file_path.suffix == '.py' else file_path.name
+
+
 def read_parser_file(file_path):
     """
     Read a parser file and return its name and contents.
 
     Returns: (parser_name, code_text)
     """
-    parser_name = file_path.stem
+    parser_name = parser_name_for(file_path)
 
     # Read the code
     with open(file_path, 'r') as f:
@@ -52,10 +62,11 @@ def apply_command(session):
         print(f"Error: Parsers directory not found at {PARSERS_DIR}")
         return
 
-    # Find all .py files
-    parser_files = [f for f in PARSERS_DIR.glob("*.py")]
+    # Find all parser files
+    parser_files = [f for f in PARSERS_DIR.iterdir()
+                    if f.is_file() and f.suffix in PARSER_EXTENSIONS and not f.name.startswith('.')]
 
-    local_parser_names = {f.stem for f in parser_files}
+    local_parser_names = {parser_name_for(f) for f in parser_files}
 
     # Get existing parsers from Cassandra
     rows = session.execute("SELECT parser_name FROM parsers")
@@ -6,6 +6,8 @@ Single instance, polls all courses from piazza_config table
 import time
 import json
 import os
+import base64
+from cryptography.hazmat.primitives.ciphers.aead import AESGCM
 from piazza_api import Piazza
 from cassandra.cluster import Cluster
 import redis
@@ -22,6 +24,26 @@ MIN_AGE_SECONDS = int(os.getenv('MIN_AGE_SECONDS', '600'))  # 10 minutes default
 
 REDIS_QUEUE = 'qa-jobs-normal'
 
+# Base64 32-byte AES key the watcher encrypts Piazza passwords with (unset = plaintext)
+PIAZZA_ENCRYPTION_KEY = os.getenv('PIAZZA_ENCRYPTION_KEY', '')
+ENCRYPTED_PASSWORD_PREFIX = 'enc:v1:'
+
+
+def decrypt_password(stored):
+    """
+    Decrypt a password stored by the watcher as 'enc:v1:' + base64(nonce || AES-GCM ciphertext).
+    Plaintext values (no prefix) are returned unchanged.
+    """
+    if not stored or not stored.startswith(ENCRYPTED_PASSWORD_PREFIX):
+        return stored
+    if not PIAZZA_ENCRYPTION_KEY:
+        raise ValueError("password is encrypted but PIAZZA_ENCRYPTION_KEY is not set")
+
+    sealed = base64.b64decode(stored[len(ENCRYPTED_PASSWORD_PREFIX):])
+    nonce, ciphertext = sealed[:12], sealed[12:]
+    key = base64.b64decode(PIAZZA_ENCRYPTION_KEY)
+    return AESGCM(key).decrypt(nonce, ciphertext, None).decode('utf-8')
+
 
 def connect_cassandra():
     """Connect to Cassandra and return session"""
@@ -61,13 +83,19 @@ def fetch_courses_from_cassandra(session):
                 print(f"  Skipping {row.class_name} - created {int(age_seconds/60)} min ago (waiting {int(MIN_AGE_SECONDS/60)} min for lectures to process)")
                 continue
 
+        try:
+            password = decrypt_password(row.password)
+        except Exception as e:
+            print(f"  Skipping {row.class_name} - could not decrypt Piazza password: {e}")
+            continue
+
         courses.append({
             'network_id': row.network_id,
             'class_name': row.class_name,
             'professor': row.professor,
             'semester': row.semester,
             'email': row.email,
-            'password': row.password,
+            'password': password,
             'created_at': row.created_at
         })
 
@@ -153,7 +181,6 @@ def process_course(course, cassandra_session, redis_client):
 
         # Login to Piazza
         piazza = Piazza()
-        print(course)
         piazza.user_login(email=course['email'], password=course['password'])
         network = piazza.network(network_id)
 
@@ -1,27 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
 	gocql "github.com/apache/cassandra-gocql-driver/v2"
+
+	"piazza-bot/shared/cassandra"
 )
 
 // ConnectCassandra establishes a connection to Cassandra
 func ConnectCassandra(config *CassandraConfig) (*gocql.Session, error) {
-	cluster := gocql.NewCluster(config.CassandraHosts...)
-	cluster.Keyspace = config.CassandraKeyspace
-	cluster.Consistency = gocql.Quorum
-	cluster.Timeout = 10 * time.Second
-	cluster.ConnectTimeout = 10 * time.Second
-
-	session, err := cluster.CreateSession()
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Cassandra: %w", err)
-	}
-
-	return session, nil
+	return cassandra.Connect(config.CassandraHosts, config.CassandraKeyspace, config.Options)
 }
 
 // FetchTranscript retrieves a single transcript from Cassandra
@@ -49,22 +43,102 @@ func FetchTranscript(session *gocql.Session, className, professor, semester stri
 	return nil, fmt.Errorf("no transcript found")
 }
 
+// CassandraStore wraps the session used by the processor's hot path and bounds each
+// operation, retries included, by CassandraConfig.OperationTimeout so a stuck replica
+// can't hold a message forever. gocql already caches prepared statements per session.
+type CassandraStore struct {
+	session *gocql.Session
+	config  *CassandraConfig
+}
+
+// NewCassandraStore creates a store over an open session
+func NewCassandraStore(session *gocql.Session, config *CassandraConfig) *CassandraStore {
+	return &CassandraStore{
+		session: session,
+		config:  config,
+	}
+}
+
+// Session returns the underlying session
+func (s *CassandraStore) Session() *gocql.Session {
+	return s.session
+}
+
+// withTimeout derives the context for one store operation
+func (s *CassandraStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.config.OperationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.config.OperationTimeout)
+}
+
 // FetchTranscriptByKey retrieves a specific transcript by its full primary key
-func FetchTranscriptByKey(session *gocql.Session, className, professor, semester, url string) (*Transcript, error) {
-	query := `
-		SELECT class_name, professor, semester, url, lecture_number, lecture_title, transcript_text
-		FROM transcripts
-		WHERE class_name = ? AND professor = ? AND semester = ? AND url = ?
-	`
+func (s *CassandraStore) FetchTranscriptByKey(ctx context.Context, className, professor, semester, url string) (*Transcript, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return FetchTranscriptByKey(ctx, s.session, className, professor, semester, url)
+}
+
+// InsertEmbeddings inserts chunk rows using batches and retries per the store's config
+func (s *CassandraStore) InsertEmbeddings(ctx context.Context, rows []*EmbeddingsRow) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return InsertEmbeddingsBatch(ctx, s.session, rows, s.config)
+}
 
+// DeleteEmbeddingsForURL removes every chunk row stored for one lecture and its keywords
+func (s *CassandraStore) DeleteEmbeddingsForURL(ctx context.Context, className, professor, semester, url string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return DeleteEmbeddingsForURL(ctx, s.session, className, professor, semester, url)
+}
+
+// InsertInvertedIndexTerm inserts a term into the inverted index
+func (s *CassandraStore) InsertInvertedIndexTerm(ctx context.Context, term string, row *EmbeddingsRow) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return InsertInvertedIndexTerm(ctx, s.session, term, row)
+}
+
+// InsertSentenceEmbeddings inserts sentence rows using batches and retries per the store's config
+func (s *CassandraStore) InsertSentenceEmbeddings(ctx context.Context, rows []*SentenceEmbeddingsRow) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return InsertSentenceEmbeddings(ctx, s.session, rows, s.config)
+}
+
+// DeleteSentenceEmbeddingsForURL removes every sentence row stored for one lecture
+func (s *CassandraStore) DeleteSentenceEmbeddingsForURL(ctx context.Context, className, professor, semester, url string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return DeleteSentenceEmbeddingsForURL(ctx, s.session, className, professor, semester, url)
+}
+
+// LectureEmbeddingCache returns the sentence embedding cache for one lecture
+func (s *CassandraStore) LectureEmbeddingCache(className, professor, semester, url string) *LectureEmbeddingCache {
+	return NewLectureEmbeddingCache(s.session, className, professor, semester, url)
+}
+
+// ErrTranscriptNotFound is returned when no transcript matches the requested key
+var ErrTranscriptNotFound = errors.New("transcript not found")
+
+// fetchTranscriptByKeyQuery selects one transcript by its full primary key
+const fetchTranscriptByKeyQuery = `
+	SELECT class_name, professor, semester, url, lecture_number, lecture_title, transcript_text
+	FROM transcripts
+	WHERE class_name = ? AND professor = ? AND semester = ? AND url = ?
+`
+
+// FetchTranscriptByKey retrieves a specific transcript by its full primary key
+func FetchTranscriptByKey(ctx context.Context, session *gocql.Session, className, professor, semester, url string) (*Transcript, error) {
 	var transcript Transcript
-	err := session.Query(query, className, professor, semester, url).Scan(
+	err := session.Query(fetchTranscriptByKeyQuery, className, professor, semester, url).ScanContext(ctx,
 		&transcript.ClassName, &transcript.Professor, &transcript.Semester,
 		&transcript.URL, &transcript.LectureNumber, &transcript.LectureTitle, &transcript.TranscriptText,
 	)
 
-	if err == gocql.ErrNotFound {
-		return nil, fmt.Errorf("transcript not found for url: %s", url)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s/%s/%s url=%s", ErrTranscriptNotFound, className, professor, semester, url)
 	}
 
 	if err != nil {
@@ -102,36 +176,416 @@ func FetchFirstTranscript(session *gocql.Session) (*Transcript, error) {
 	return nil, fmt.Errorf("no transcripts with text found")
 }
 
+// insertEmbeddingQuery inserts a single row into the embeddings table
+const insertEmbeddingQuery = `
+	INSERT INTO embeddings (
+		class_name, professor, semester, url, chunk_index,
+		chunk_text, embedding, token_count, lecture_title, lecture_timestamp, lecture_start_ms,
+		lecture_end_timestamp, lecture_order, keywords, continues_previous, untimed,
+		model_name, embedding_dim, created_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// embeddingArgs returns the bind values for insertEmbeddingQuery
+func embeddingArgs(row *EmbeddingsRow, createdAt time.Time) []interface{} {
+	return []interface{}{
+		row.ClassName, row.Professor, row.Semester, row.URL, row.ChunkIndex,
+		row.ChunkText, row.Embedding, row.TokenCount, row.LectureTitle, row.LectureTimestamp, row.LectureStartMs,
+		row.LectureEndTime, row.LectureOrder, row.Keywords, row.ContinuesPrevious, row.Untimed,
+		row.ModelName, row.EmbeddingDim, createdAt,
+	}
+}
+
 // InsertEmbedding inserts a processed chunk into the embeddings table
-func InsertEmbedding(session *gocql.Session, row *EmbeddingsRow) error {
+func InsertEmbedding(ctx context.Context, session *gocql.Session, row *EmbeddingsRow) error {
+	return session.Query(insertEmbeddingQuery, embeddingArgs(row, time.Now())...).ExecContext(ctx)
+}
+
+// deleteEmbeddingsForURLQuery deletes all clustering rows of one URL
+const deleteEmbeddingsForURLQuery = `
+	DELETE FROM embeddings
+	WHERE class_name = ? AND professor = ? AND semester = ? AND url = ?
+`
+
+// fetchChunkTextsForURLQuery selects the text of every chunk row of one URL
+const fetchChunkTextsForURLQuery = `
+	SELECT chunk_text FROM embeddings
+	WHERE class_name = ? AND professor = ? AND semester = ? AND url = ?
+`
+
+// deleteKeywordsForURLQuery deletes one term's inverted index rows for one URL
+const deleteKeywordsForURLQuery = `
+	DELETE FROM keywords
+	WHERE term = ? AND class_name = ? AND professor = ? AND semester = ? AND url = ?
+`
+
+// DeleteEmbeddingsForURL removes every chunk row stored for one lecture, so a reprocessed
+// transcript doesn't leave stale higher-index chunks from a previous, longer run. The
+// keywords table is partitioned by term, so the lecture's inverted index rows are found
+// by re-deriving the terms from the stored chunk text and are deleted first; if that
+// fails the chunk rows are kept so a retry can still find them.
+func DeleteEmbeddingsForURL(ctx context.Context, session *gocql.Session, className, professor, semester, url string) error {
+	terms := make(map[string]bool)
+	iter := session.Query(fetchChunkTextsForURLQuery, className, professor, semester, url).IterContext(ctx)
+	var chunkText string
+	for iter.Scan(&chunkText) {
+		for _, term := range WordsFromText(chunkText) {
+			terms[term] = true
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return fmt.Errorf("error reading chunks for %s: %w", url, err)
+	}
+
+	for term := range terms {
+		if err := session.Query(deleteKeywordsForURLQuery, term, className, professor, semester, url).ExecContext(ctx); err != nil {
+			return fmt.Errorf("error deleting keyword %q for %s: %w", term, url, err)
+		}
+	}
+
+	if err := session.Query(deleteEmbeddingsForURLQuery, className, professor, semester, url).ExecContext(ctx); err != nil {
+		return fmt.Errorf("error deleting embeddings for %s: %w", url, err)
+	}
+	return nil
+}
+
+// fetchEmbeddingsForURLQuery selects every chunk row of one URL, in chunk order
+const fetchEmbeddingsForURLQuery = `
+	SELECT class_name, professor, semester, url, chunk_index,
+		chunk_text, embedding, token_count, lecture_title, lecture_timestamp, lecture_start_ms,
+		lecture_end_timestamp, lecture_order, keywords, continues_previous, untimed,
+		model_name, embedding_dim
+	FROM embeddings
+	WHERE class_name = ? AND professor = ? AND semester = ? AND url = ?
+`
+
+// FetchEmbeddingsForURL returns the chunk rows stored for one lecture ordered by chunk
+// index, or an empty slice if the lecture has none
+func FetchEmbeddingsForURL(ctx context.Context, session *gocql.Session, className, professor, semester, url string) ([]*EmbeddingsRow, error) {
+	iter := session.Query(fetchEmbeddingsForURLQuery, className, professor, semester, url).IterContext(ctx)
+
+	var rows []*EmbeddingsRow
+	for {
+		var row EmbeddingsRow
+		if !iter.Scan(&row.ClassName, &row.Professor, &row.Semester, &row.URL, &row.ChunkIndex,
+			&row.ChunkText, &row.Embedding, &row.TokenCount, &row.LectureTitle, &row.LectureTimestamp, &row.LectureStartMs,
+			&row.LectureEndTime, &row.LectureOrder, &row.Keywords, &row.ContinuesPrevious, &row.Untimed,
+			&row.ModelName, &row.EmbeddingDim) {
+			break
+		}
+		rows = append(rows, &row)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("error fetching embeddings for %s: %w", url, err)
+	}
+	return rows, nil
+}
+
+// InsertEmbeddingWithRetry calls InsertEmbedding, retrying with exponential backoff on
+// transient errors. Non-retryable errors are returned immediately.
+func InsertEmbeddingWithRetry(ctx context.Context, session *gocql.Session, row *EmbeddingsRow, config *CassandraConfig) error {
+	return withInsertRetry(ctx, config, fmt.Sprintf("chunk %d", row.ChunkIndex), func() error {
+		return InsertEmbedding(ctx, session, row)
+	})
+}
+
+// InsertEmbeddingsBatch inserts rows using UNLOGGED batches of up to config.InsertBatchSize.
+// All chunks of a lecture share a partition key, so each batch is a single-partition write.
+// A batch rejected as too large is retried row by row.
+func InsertEmbeddingsBatch(ctx context.Context, session *gocql.Session, rows []*EmbeddingsRow, config *CassandraConfig) error {
+	batchSize := config.InsertBatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		group := rows[start:end]
+
+		if len(group) == 1 {
+			if err := InsertEmbeddingWithRetry(ctx, session, group[0], config); err != nil {
+				return fmt.Errorf("failed to insert chunk %d: %w", group[0].ChunkIndex, err)
+			}
+			continue
+		}
+
+		desc := fmt.Sprintf("chunks %d-%d", group[0].ChunkIndex, group[len(group)-1].ChunkIndex)
+		err := withInsertRetry(ctx, config, desc, func() error {
+			batch := session.Batch(gocql.UnloggedBatch)
+			createdAt := time.Now()
+			for _, row := range group {
+				batch.Query(insertEmbeddingQuery, embeddingArgs(row, createdAt)...)
+			}
+			return batch.ExecContext(ctx)
+		})
+		if err == nil {
+			continue
+		}
+
+		if !isBatchTooLarge(err) {
+			return fmt.Errorf("failed to insert %s: %w", desc, err)
+		}
+
+		// Fall back to single inserts
+		slog.Info("Batch too large, inserting individually", "batch", desc)
+		for _, row := range group {
+			if err := InsertEmbeddingWithRetry(ctx, session, row, config); err != nil {
+				return fmt.Errorf("failed to insert chunk %d: %w", row.ChunkIndex, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// withInsertRetry runs insert, retrying with exponential backoff on transient errors
+// until ctx is cancelled
+func withInsertRetry(ctx context.Context, config *CassandraConfig, desc string, insert func() error) error {
+	backoff := config.InsertBaseBackoff
+
+	var err error
+	for attempt := 1; attempt <= config.InsertMaxAttempts; attempt++ {
+		err = insert()
+		if err == nil || !isRetryableCassandraError(err) {
+			return err
+		}
+
+		if attempt < config.InsertMaxAttempts {
+			slog.Warn("Insert failed, retrying", "insert", desc,
+				"attempt", attempt, "attempts", config.InsertMaxAttempts, "backoff", backoff, "error", err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return fmt.Errorf("insert of %s cancelled: %w", desc, ctx.Err())
+			}
+			backoff *= 2
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", config.InsertMaxAttempts, err)
+}
+
+// isBatchTooLarge reports whether Cassandra rejected a batch for exceeding batch_size_fail_threshold
+func isBatchTooLarge(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "batch too large")
+}
+
+// isRetryableCassandraError reports whether err is a transient coordinator-side failure
+func isRetryableCassandraError(err error) bool {
+	var writeTimeout *gocql.RequestErrWriteTimeout
+	var unavailable *gocql.RequestErrUnavailable
+
+	return errors.As(err, &writeTimeout) ||
+		errors.As(err, &unavailable) ||
+		errors.Is(err, gocql.ErrTimeoutNoResponse)
+}
+
+// isCassandraConnectivityError reports whether err means the cluster couldn't be reached
+func isCassandraConnectivityError(err error) bool {
+	return isRetryableCassandraError(err) || errors.Is(err, gocql.ErrNoConnections)
+}
+
+// insertSentenceEmbeddingQuery inserts a single row into the sentence_embeddings table
+const insertSentenceEmbeddingQuery = `
+	INSERT INTO sentence_embeddings (
+		class_name, professor, semester, url, sentence_index,
+		chunk_index, sentence_text, embedding, lecture_timestamp, lecture_start_ms, model_name
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// sentenceEmbeddingArgs returns the bind values for insertSentenceEmbeddingQuery
+func sentenceEmbeddingArgs(row *SentenceEmbeddingsRow) []interface{} {
+	return []interface{}{
+		row.ClassName, row.Professor, row.Semester, row.URL, row.SentenceIndex,
+		row.ChunkIndex, row.SentenceText, row.Embedding, row.LectureTimestamp, row.LectureStartMs, row.ModelName,
+	}
+}
+
+// InsertSentenceEmbeddings inserts sentence rows using UNLOGGED batches of up to
+// config.InsertBatchSize, like InsertEmbeddingsBatch. A batch rejected as too large is
+// retried row by row.
+func InsertSentenceEmbeddings(ctx context.Context, session *gocql.Session, rows []*SentenceEmbeddingsRow, config *CassandraConfig) error {
+	batchSize := config.InsertBatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	insertOne := func(row *SentenceEmbeddingsRow) error {
+		err := withInsertRetry(ctx, config, fmt.Sprintf("sentence %d", row.SentenceIndex), func() error {
+			return session.Query(insertSentenceEmbeddingQuery, sentenceEmbeddingArgs(row)...).ExecContext(ctx)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to insert sentence %d: %w", row.SentenceIndex, err)
+		}
+		return nil
+	}
+
+	for start := 0; start < len(rows); start += batchSize {
+		group := rows[start:min(start+batchSize, len(rows))]
+
+		if len(group) == 1 {
+			if err := insertOne(group[0]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		desc := fmt.Sprintf("sentences %d-%d", group[0].SentenceIndex, group[len(group)-1].SentenceIndex)
+		err := withInsertRetry(ctx, config, desc, func() error {
+			batch := session.Batch(gocql.UnloggedBatch)
+			for _, row := range group {
+				batch.Query(insertSentenceEmbeddingQuery, sentenceEmbeddingArgs(row)...)
+			}
+			return batch.ExecContext(ctx)
+		})
+		if err == nil {
+			continue
+		}
+
+		if !isBatchTooLarge(err) {
+			return fmt.Errorf("failed to insert %s: %w", desc, err)
+		}
+
+		// Fall back to single inserts
+		slog.Info("Batch too large, inserting individually", "batch", desc)
+		for _, row := range group {
+			if err := insertOne(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteSentenceEmbeddingsForURLQuery deletes all sentence rows of one URL
+const deleteSentenceEmbeddingsForURLQuery = `
+	DELETE FROM sentence_embeddings
+	WHERE class_name = ? AND professor = ? AND semester = ? AND url = ?
+`
+
+// DeleteSentenceEmbeddingsForURL removes every sentence row stored for one lecture, so a
+// reprocessed transcript with fewer sentences doesn't leave stale rows behind
+func DeleteSentenceEmbeddingsForURL(ctx context.Context, session *gocql.Session, className, professor, semester, url string) error {
+	if err := session.Query(deleteSentenceEmbeddingsForURLQuery, className, professor, semester, url).ExecContext(ctx); err != nil {
+		return fmt.Errorf("error deleting sentence embeddings for %s: %w", url, err)
+	}
+	return nil
+}
+
+// LectureEmbeddingCache is an EmbeddingCache over the sentence_embedding_cache table,
+// scoped to a single lecture
+type LectureEmbeddingCache struct {
+	session   *gocql.Session
+	className string
+	professor string
+	semester  string
+	url       string
+}
+
+// NewLectureEmbeddingCache returns a sentence embedding cache for one lecture
+func NewLectureEmbeddingCache(session *gocql.Session, className, professor, semester, url string) *LectureEmbeddingCache {
+	return &LectureEmbeddingCache{
+		session:   session,
+		className: className,
+		professor: professor,
+		semester:  semester,
+		url:       url,
+	}
+}
+
+// Lookup returns cached embeddings for the given text hashes
+func (c *LectureEmbeddingCache) Lookup(ctx context.Context, hashes []string) (map[string][]float32, error) {
+	wanted := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		wanted[h] = true
+	}
+
 	query := `
-		INSERT INTO embeddings (
-			class_name, professor, semester, url, chunk_index,
-			chunk_text, embedding, token_count, lecture_title, lecture_timestamp, created_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		SELECT text_hash, embedding
+		FROM sentence_embedding_cache
+		WHERE class_name = ? AND professor = ? AND semester = ? AND url = ?
 	`
 
-	return session.Query(query,
-		row.ClassName, row.Professor, row.Semester, row.URL, row.ChunkIndex,
-		row.ChunkText, row.Embedding, row.TokenCount, row.LectureTitle, row.LectureTimestamp, time.Now(),
-	).Exec()
+	iter := c.session.Query(query, c.className, c.professor, c.semester, c.url).IterContext(ctx)
+
+	found := make(map[string][]float32)
+	var hash string
+	var embedding []float32
+	for iter.Scan(&hash, &embedding) {
+		if wanted[hash] {
+			found[hash] = embedding
+		}
+		embedding = nil
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("error fetching embedding cache: %w", err)
+	}
+
+	return found, nil
 }
 
-// InsertInvertedIndexTerm inserts a term into the inverted index
-func InsertInvertedIndexTerm(session *gocql.Session, term string, row *EmbeddingsRow) error {
+// Store writes embeddings to the cache
+func (c *LectureEmbeddingCache) Store(ctx context.Context, entries map[string][]float32) error {
 	query := `
-		INSERT INTO keywords (
-			term, class_name, professor, semester, url, chunk_index
+		INSERT INTO sentence_embedding_cache (
+			class_name, professor, semester, url, text_hash, embedding
 		) VALUES (?, ?, ?, ?, ?, ?)
 	`
 
-	return session.Query(query,
+	for hash, embedding := range entries {
+		if err := c.session.Query(query,
+			c.className, c.professor, c.semester, c.url, hash, embedding,
+		).ExecContext(ctx); err != nil {
+			return fmt.Errorf("error storing embedding cache entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// insertInvertedIndexTermQuery inserts one term -> chunk mapping
+const insertInvertedIndexTermQuery = `
+	INSERT INTO keywords (
+		term, class_name, professor, semester, url, chunk_index
+	) VALUES (?, ?, ?, ?, ?, ?)
+`
+
+// InsertInvertedIndexTerm inserts a term into the inverted index
+func InsertInvertedIndexTerm(ctx context.Context, session *gocql.Session, term string, row *EmbeddingsRow) error {
+	return session.Query(insertInvertedIndexTermQuery,
 		term, row.ClassName, row.Professor, row.Semester, row.URL, row.ChunkIndex,
-	).Exec()
+	).ExecContext(ctx)
 }
 
 // TokenizeText is a helper function that extracts terms from text for inverted index
 func WordsFromText(text string) []string {
+	words := splitTerms(text)
+
+	// filter out short and common words, deduplicate
+	termSet := make(map[string]bool)
+	for _, word := range words {
+		if len(word) > 2 {
+			termSet[word] = true
+		}
+	}
+
+	// Convert back to slice
+	terms := make([]string, 0, len(termSet))
+	for term := range termSet {
+		terms = append(terms, term)
+	}
+
+	return terms
+}
+
+// splitTerms lowercases text and splits it into words, dropping punctuation
+func splitTerms(text string) []string {
 
 	text = strings.ToLower(text)
 
@@ -154,21 +608,5 @@ func WordsFromText(text string) []string {
 		"\t", " ",
 	)
 	text = replacer.Replace(text)
-	words := strings.Fields(text)
-
-	// filter out short and common words, deduplicate
-	termSet := make(map[string]bool)
-	for _, word := range words {
-		if len(word) > 2 {
-			termSet[word] = true
-		}
-	}
-
-	// Convert back to slice
-	terms := make([]string, 0, len(termSet))
-	for term := range termSet {
-		terms = append(terms, term)
-	}
-
-	return terms
+	return strings.Fields(text)
 }
@@ -3,8 +3,12 @@ package main
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"math"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // Calculates hinge loss based on tokens window.
@@ -33,8 +37,62 @@ func SegmentReward(i, j int, prefixSim []float32) float32 {
 	return prefixSim[j-1] - prefixSim[i]
 }
 
+// CentroidReward scores segment [i..j-1] by the mean cosine similarity of each sentence
+// to the segment centroid, scaled by the segment's internal edge count (j-i-1) so it is
+// on the same scale as SegmentReward.
+//
+// prefixUnit[k] is the sum of the unit-normalized embeddings of sentences 0..k-1.
+// With C the sum of unit vectors in the segment, sum(cos(s, C)) = C.C/|C| = |C|,
+// so the mean similarity is |C|/(j-i) and costs O(dim) per segment.
+func CentroidReward(i, j int, prefixUnit [][]float32) float32 {
+	size := j - i
+	if size <= 1 {
+		return 0
+	}
+
+	var sq float64
+	for d := range prefixUnit[j] {
+		v := float64(prefixUnit[j][d] - prefixUnit[i][d])
+		sq += v * v
+	}
+	meanSim := float32(math.Sqrt(sq)) / float32(size)
+
+	return meanSim * float32(size-1)
+}
+
+// ChunkStats breaks down the DP score of one chunk
+type ChunkStats struct {
+	Reward       float32 // segment reward from the similarity series
+	SizePenalty  float32 // hinge penalty for exceeding OptimalSize
+	ChunkPenalty float32 // flat per-chunk penalty (ChunkingConfig.ChunkPenalty)
+}
+
+// ChunkingStats explains the boundaries chosen by ExtractChunksWithStats, for tuning
+// LambdaSize, ChunkPenalty, and OptimalSize
+type ChunkingStats struct {
+	NormalizedSimilarities []float32    // min-max normalized adjacent-sentence similarities
+	Chunks                 []ChunkStats // one per returned chunk
+	FinalScore             float32      // dp[n]: sum over chunks of Reward - SizePenalty - ChunkPenalty
+}
+
 // Partition sentences into chunks. Maximizes semantic coherence while penalizing oversized chunks
 func (cfg ChunkingConfig) ExtractChunksFromSentences(sentences []*Sentence) ([]*Chunk, error) {
+	return cfg.extractChunks(sentences, nil)
+}
+
+// ExtractChunksWithStats is ExtractChunksFromSentences plus diagnostics on why the DP chose
+// its boundaries. Stats are empty for fewer than two sentences, where no DP runs.
+func (cfg ChunkingConfig) ExtractChunksWithStats(sentences []*Sentence) ([]*Chunk, *ChunkingStats, error) {
+	stats := &ChunkingStats{}
+	chunks, err := cfg.extractChunks(sentences, stats)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chunks, stats, nil
+}
+
+// extractChunks implements ExtractChunksFromSentences, filling stats if it is non-nil
+func (cfg ChunkingConfig) extractChunks(sentences []*Sentence, stats *ChunkingStats) ([]*Chunk, error) {
 	//
 	// DP Definition:
 	// dp[j] = best score for optimally chunking sentences 0..j-1
@@ -55,11 +113,31 @@ func (cfg ChunkingConfig) ExtractChunksFromSentences(sentences []*Sentence) ([]*
 	if len(sentences) == 0 {
 		return []*Chunk{}, nil
 	}
+
+	// Every sentence must fit within MaxSize; ExtractSentencesFromFrames should already
+	// guarantee this, so anything left over is split here rather than failing the lecture.
+	// A single oversized sentence is split too, before the one-sentence shortcut.
+	sentences, err := cfg.hardSplitOversized(sentences)
+	if err != nil {
+		return nil, err
+	}
 	if len(sentences) == 1 {
+		if cfg.MinChunks > 1 {
+			return nil, fmt.Errorf("MinChunks=%d is infeasible for a single sentence", cfg.MinChunks)
+		}
+		if sentences[0].Embedding == nil {
+			return nil, fmt.Errorf("sentence 0 Embedding is nil. Please use EmbedSentences first.")
+		}
+		if err := ValidateEmbedding(sentences[0].Embedding); err != nil {
+			return nil, fmt.Errorf("sentence 0: %w", err)
+		}
 		chunk := &Chunk{
 			StartTime:          sentences[0].StartTime,
+			StartMillis:        sentences[0].StartMillis,
+			EndTime:            sentences[0].EndTime,
 			NumSentences:       1,
 			SentenceEmbeddings: [][]float32{sentences[0].Embedding},
+			Sentences:          sentences[:1],
 			ChunkIndex:         0,
 			TokenCount:         sentences[0].TokenCount,
 			Text:               sentences[0].Text,
@@ -67,28 +145,24 @@ func (cfg ChunkingConfig) ExtractChunksFromSentences(sentences []*Sentence) ([]*
 		}
 		return []*Chunk{chunk}, nil
 	}
-
 	n := len(sentences)
 
-	// Sanity check: every sentence must fit within MaxSize
+	// NaN makes every DP comparison false, so reject bad model output up front
 	for idx, s := range sentences {
-		if s.TokenCount > cfg.MaxSize {
-			return nil, fmt.Errorf("sentence %d has TokenCount=%d > MaxSize=%d; cannot chunk (issue with ExtractSentencesFromFrames)", idx, s.TokenCount, cfg.MaxSize)
+		if err := ValidateEmbedding(s.Embedding); err != nil {
+			return nil, fmt.Errorf("sentence %d: %w", idx, err)
 		}
 	}
 
-	// precompute adjacent cosine similarities
-	sim := make([]float32, n-1)
-	for i := 0; i < n-1; i++ {
-		if sentences[i].Embedding == nil {
+	for i, s := range sentences {
+		if s.Embedding == nil {
 			return nil, fmt.Errorf("sentence %d Embedding is nil. Please use EmbedSentences first.", i)
 		}
-		if sentences[i+1].Embedding == nil {
-			return nil, fmt.Errorf("sentence %d Embedding is nil. Please use EmbedSentences first.", i+1)
-		}
-		sim[i], _ = CosineSimilarity(sentences[i].Embedding, sentences[i+1].Embedding)
 	}
 
+	// precompute adjacent cosine similarities
+	sim := adjacentSimilarities(sentences)
+
 	// Min-max normalizes similarities to [0, 1] range to keep rewards positive
 	// This ensures that merging similar sentences is always rewarded
 	// while dissimilar sentences get less reward but never negative
@@ -114,6 +188,9 @@ func (cfg ChunkingConfig) ExtractChunksFromSentences(sentences []*Sentence) ([]*
 			sim[i] = (v - minSim) / simRange
 		}
 	}
+	if stats != nil {
+		stats.NormalizedSimilarities = append([]float32(nil), sim...)
+	}
 
 	// prefixSim and prefixTokens are prefix-sum arrays over adjacent sentence
 	// similarities and tokens.
@@ -147,6 +224,307 @@ func (cfg ChunkingConfig) ExtractChunksFromSentences(sentences []*Sentence) ([]*
 		prefixTokens[i+1] = prefixTokens[i] + sentences[i].TokenCount
 	}
 
+	// Pick the segment reward function
+	var reward func(i, j int) float32
+	switch cfg.ScoringMode {
+	case "", ScoringModeAdjacent:
+		reward = func(i, j int) float32 { return SegmentReward(i, j, prefixSim) }
+	case ScoringModeCentroid:
+		prefixUnit, err := buildPrefixUnitEmbeddings(sentences)
+		if err != nil {
+			return nil, err
+		}
+		reward = func(i, j int) float32 { return CentroidReward(i, j, prefixUnit) }
+	default:
+		return nil, fmt.Errorf("unknown ScoringMode %q", cfg.ScoringMode)
+	}
+
+	start, err := cfg.segment(n, reward, prefixTokens)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MinChunks > 0 || cfg.MaxChunks > 0 {
+		start, err = cfg.segmentWithChunkCount(start, reward, prefixTokens)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Reconstruct chunk starts from parent pointers
+	var chunkStarts []int
+	for pos := n; pos > 0; pos = start[pos] {
+		chunkStarts = append(chunkStarts, start[pos])
+	}
+
+	// Reverse since we built them backwards
+	for i := 0; i < len(chunkStarts)/2; i++ {
+		j := len(chunkStarts) - 1 - i
+		chunkStarts[i], chunkStarts[j] = chunkStarts[j], chunkStarts[i]
+	}
+
+	if stats != nil {
+		for c, from := range chunkStarts {
+			to := n
+			if c+1 < len(chunkStarts) {
+				to = chunkStarts[c+1]
+			}
+			penalty, _ := cfg.ComputePenalty(from, to, prefixTokens)
+			chunkStats := ChunkStats{
+				Reward:       reward(from, to),
+				SizePenalty:  penalty,
+				ChunkPenalty: cfg.ChunkPenalty,
+			}
+			stats.Chunks = append(stats.Chunks, chunkStats)
+			stats.FinalScore += chunkStats.Reward - chunkStats.SizePenalty - chunkStats.ChunkPenalty
+		}
+	}
+
+	return cfg.assembleChunks(sentences, chunkStarts), nil
+}
+
+// assembleChunks builds chunks from the first sentence index of each chunk (ascending,
+// starting at 0), then applies sentence overlap and continuation flags.
+func (cfg ChunkingConfig) assembleChunks(sentences []*Sentence, chunkStarts []int) []*Chunk {
+	chunks := make([]*Chunk, len(chunkStarts))
+	for c, from := range chunkStarts {
+		to := len(sentences)
+		if c+1 < len(chunkStarts) {
+			to = chunkStarts[c+1]
+		}
+		chunkSentences := sentences[from:to]
+
+		// Build chunk
+		chunk := &Chunk{
+			StartTime:          chunkSentences[0].StartTime,
+			StartMillis:        chunkSentences[0].StartMillis,
+			EndTime:            chunkSentences[len(chunkSentences)-1].EndTime,
+			NumSentences:       len(chunkSentences),
+			SentenceEmbeddings: make([][]float32, len(chunkSentences)),
+			Sentences:          chunkSentences,
+			ChunkIndex:         c,
+			Embedding:          nil, // handled by EmbedChunks()
+		}
+
+		tokenCount := 0
+		textParts := make([]string, len(chunkSentences))
+
+		for i, s := range chunkSentences {
+			chunk.SentenceEmbeddings[i] = s.Embedding
+			tokenCount += s.TokenCount
+			textParts[i] = s.Text
+		}
+
+		chunk.TokenCount = tokenCount
+		chunk.Text = normalizeWhitespace(strings.Join(textParts, " "))
+
+		chunks[c] = chunk
+	}
+
+	if cfg.OverlapSentences > 0 {
+		applySentenceOverlap(chunks, chunkStarts, sentences, cfg.OverlapSentences)
+	}
+
+	if cfg.ContinuationThreshold > 0 {
+		AnnotateContinuations(chunks, cfg.ContinuationThreshold)
+	}
+
+	return chunks
+}
+
+// Chunk partitions sentences with the configured Algorithm
+func (cfg ChunkingConfig) Chunk(sentences []*Sentence) ([]*Chunk, error) {
+	switch cfg.Algorithm {
+	case "", ChunkingAlgorithmDP:
+		return cfg.ExtractChunksFromSentences(sentences)
+	case ChunkingAlgorithmGreedy:
+		return cfg.GreedyChunk(sentences, cfg.GreedyThreshold)
+	default:
+		return nil, fmt.Errorf("unknown chunking Algorithm %q", cfg.Algorithm)
+	}
+}
+
+// GreedyChunk is a single-pass alternative to the DP: it starts a new chunk whenever the
+// cosine similarity between adjacent sentences drops below threshold, or when adding the
+// next sentence would take the chunk past OptimalSize tokens. Output matches
+// ExtractChunksFromSentences, including overlap and continuation flags.
+func (cfg ChunkingConfig) GreedyChunk(sentences []*Sentence, threshold float32) ([]*Chunk, error) {
+	if len(sentences) == 0 {
+		return []*Chunk{}, nil
+	}
+
+	sentences, err := cfg.hardSplitOversized(sentences)
+	if err != nil {
+		return nil, err
+	}
+	for idx, s := range sentences {
+		if s.Embedding == nil {
+			return nil, fmt.Errorf("sentence %d Embedding is nil. Please use EmbedSentences first.", idx)
+		}
+		if err := ValidateEmbedding(s.Embedding); err != nil {
+			return nil, fmt.Errorf("sentence %d: %w", idx, err)
+		}
+	}
+
+	chunkStarts := []int{0}
+	tokens := sentences[0].TokenCount
+	for i := 1; i < len(sentences); i++ {
+		split := tokens+sentences[i].TokenCount > cfg.OptimalSize
+		if !split {
+			sim, err := CosineSimilarity(sentences[i-1].Embedding, sentences[i].Embedding)
+			split = err != nil || sim < threshold
+		}
+
+		if split {
+			chunkStarts = append(chunkStarts, i)
+			tokens = 0
+		}
+		tokens += sentences[i].TokenCount
+	}
+
+	return cfg.assembleChunks(sentences, chunkStarts), nil
+}
+
+// hardSplitOversized splits sentences over MaxSize tokens. With Resplit set, each is split
+// and re-embedded by the model. Otherwise it is cut into evenly sized word runs whose token
+// counts are estimated proportionally to word count and which keep the original
+// sentence's embedding, so that path is only a last resort. Pieces keep the original times.
+func (cfg ChunkingConfig) hardSplitOversized(sentences []*Sentence) ([]*Sentence, error) {
+	maxSize := cfg.MaxSize
+	var result []*Sentence
+	for idx, s := range sentences {
+		words := strings.Fields(s.Text)
+		if maxSize <= 0 || s.TokenCount <= maxSize || len(words) < 2 {
+			if result != nil {
+				result = append(result, s)
+			}
+			continue
+		}
+		if result == nil {
+			result = append(make([]*Sentence, 0, len(sentences)+1), sentences[:idx]...)
+		}
+
+		if cfg.Resplit != nil {
+			slog.Warn("Sentence exceeds MaxSize, re-splitting it",
+				"sentence_index", idx, "token_count", s.TokenCount, "max_size", maxSize)
+			pieces, err := cfg.Resplit(s, maxSize)
+			if err != nil {
+				return nil, fmt.Errorf("failed to re-split sentence %d: %w", idx, err)
+			}
+			result = append(result, pieces...)
+			continue
+		}
+
+		// Add pieces until the largest one's estimate fits
+		pieces := min((s.TokenCount+maxSize-1)/maxSize, len(words))
+		for pieces < len(words) && s.TokenCount*((len(words)+pieces-1)/pieces)/len(words) > maxSize {
+			pieces++
+		}
+		slog.Warn("Sentence exceeds MaxSize, hard-splitting it",
+			"sentence_index", idx, "token_count", s.TokenCount, "max_size", maxSize, "pieces", pieces)
+
+		for p := 0; p < pieces; p++ {
+			from, to := p*len(words)/pieces, (p+1)*len(words)/pieces
+			result = append(result, &Sentence{
+				Text:        strings.Join(words[from:to], " "),
+				StartTime:   s.StartTime,
+				StartMillis: s.StartMillis,
+				EndTime:     s.EndTime,
+				Embedding:   s.Embedding,
+				TokenCount:  s.TokenCount * (to - from) / len(words),
+			})
+		}
+	}
+
+	if result == nil {
+		return sentences, nil
+	}
+	return result, nil
+}
+
+// AnnotateContinuations sets ContinuesPrevious on chunks whose first sentence is at least
+// threshold cosine-similar to the previous chunk's last sentence. This flags boundaries
+// the DP was forced to place for size reasons rather than topic changes.
+func AnnotateContinuations(chunks []*Chunk, threshold float32) {
+	for c := 1; c < len(chunks); c++ {
+		prev := chunks[c-1].SentenceEmbeddings
+		curr := chunks[c].SentenceEmbeddings
+		if len(prev) == 0 || len(curr) == 0 {
+			continue
+		}
+
+		sim, err := CosineSimilarity(prev[len(prev)-1], curr[0])
+		if err != nil {
+			continue
+		}
+		chunks[c].ContinuesPrevious = sim >= threshold
+	}
+}
+
+// minSentencesPerWorker keeps short lectures on one goroutine, where the fan-out would
+// cost more than the similarities themselves
+const minSentencesPerWorker = 256
+
+// adjacentSimilarities returns the cosine similarity of each sentence to the next, split
+// across up to GOMAXPROCS workers. Each worker writes its own range of the result, and
+// every value is computed exactly as in a sequential loop.
+func adjacentSimilarities(sentences []*Sentence) []float32 {
+	sim := make([]float32, len(sentences)-1)
+
+	workers := min(runtime.GOMAXPROCS(0), len(sim)/minSentencesPerWorker)
+	if workers <= 1 {
+		for i := range sim {
+			sim[i], _ = CosineSimilarity(sentences[i].Embedding, sentences[i+1].Embedding)
+		}
+		return sim
+	}
+
+	var wg sync.WaitGroup
+	perWorker := (len(sim) + workers - 1) / workers
+	for from := 0; from < len(sim); from += perWorker {
+		to := min(from+perWorker, len(sim))
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			for i := from; i < to; i++ {
+				sim[i], _ = CosineSimilarity(sentences[i].Embedding, sentences[i+1].Embedding)
+			}
+		}(from, to)
+	}
+	wg.Wait()
+	return sim
+}
+
+// applySentenceOverlap prepends the last `overlap` sentences of the previous chunk to
+// each chunk's Text (except the first) and adds their tokens to TokenCount.
+// This runs after the DP, so overlap never counts toward the MaxSize constraint.
+func applySentenceOverlap(chunks []*Chunk, chunkStarts []int, sentences []*Sentence, overlap int) {
+	for c := 1; c < len(chunks); c++ {
+		prevStart := chunkStarts[c-1]
+		from := chunkStarts[c] - overlap
+		if from < prevStart {
+			from = prevStart // previous chunk is shorter than the overlap
+		}
+
+		overlapSentences := sentences[from:chunkStarts[c]]
+		textParts := make([]string, 0, len(overlapSentences)+1)
+		for _, s := range overlapSentences {
+			textParts = append(textParts, s.Text)
+			chunks[c].TokenCount += s.TokenCount
+		}
+		textParts = append(textParts, chunks[c].Text)
+		chunks[c].Text = normalizeWhitespace(strings.Join(textParts, " "))
+	}
+}
+
+// normalizeWhitespace collapses runs of whitespace to single spaces and trims the ends,
+// so sentences joined at split boundaries don't leave double spaces in chunk text
+func normalizeWhitespace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// segment runs the chunking DP over n sentences and returns start[], where start[j] is
+// the first sentence of the last chunk of the best segmentation of sentences 0..j-1
+func (cfg ChunkingConfig) segment(n int, reward func(i, j int) float32, prefixTokens []int) ([]int, error) {
 	dp := make([]float32, n+1)
 	dp[0] = 0
 
@@ -170,10 +548,8 @@ func (cfg ChunkingConfig) ExtractChunksFromSentences(sentences []*Sentence) ([]*
 				continue // Segment too large, skip
 			}
 
-			reward := SegmentReward(i, j, prefixSim)
-
 			// Score = previous best + reward for this segment - size penalty - per-chunk penalty
-			score := dp[i] + reward - penalty - cfg.ChunkPenalty
+			score := dp[i] + reward(i, j) - penalty - cfg.ChunkPenalty
 
 			if score > dp[j] {
 				dp[j] = score
@@ -186,54 +562,149 @@ func (cfg ChunkingConfig) ExtractChunksFromSentences(sentences []*Sentence) ([]*
 	if math.IsInf(float64(dp[n]), -1) || start[n] == -1 {
 		return nil, fmt.Errorf("DP failed: no valid segmentation found under MaxSize=%d; could be error in preprocessing", cfg.MaxSize)
 	}
+	return start, nil
+}
 
-	// Reconstruct chunks from parent pointers
-	var chunks []*Chunk
-	var chunkIndex int
-	pos := n
+// segmentWithChunkCount enforces [MinChunks, MaxChunks] (0 = unbounded) on start, the
+// result of segment. If its chunk count is already within bounds it is returned as is.
+// Otherwise the DP is rerun with an extra dimension for chunk count:
+//
+// dp[j][k] = best score for chunking sentences 0..j-1 into exactly k chunks
+// dp[j][k] = max{i < j} (dp[i][k-1] + reward(i, j) - penalty(i, j) - ChunkPenalty)
+//
+// k only goes up to the violated limit: too many chunks are cut to the best k up to
+// MaxChunks, too few are raised to exactly MinChunks, the count nearest the unconstrained
+// optimum. The returned parent array has the same meaning as segment's start[] along
+// the chosen path.
+func (cfg ChunkingConfig) segmentWithChunkCount(start []int, reward func(i, j int) float32, prefixTokens []int) ([]int, error) {
+	n := len(start) - 1
+	minK := cfg.MinChunks
+	if minK < 1 {
+		minK = 1
+	}
+	maxK := cfg.MaxChunks
+	if maxK <= 0 || maxK > n {
+		maxK = n
+	}
+	if minK > maxK {
+		return nil, fmt.Errorf("chunk count constraints infeasible: MinChunks=%d, MaxChunks=%d, %d sentences", cfg.MinChunks, cfg.MaxChunks, n)
+	}
 
-	for pos > 0 {
-		prevPos := start[pos]
-		chunkSentences := sentences[prevPos:pos]
+	count := 0
+	for pos := n; pos > 0; pos = start[pos] {
+		count++
+	}
+	if count >= minK && count <= maxK {
+		return start, nil
+	}
+	if count < minK {
+		maxK = minK
+	}
 
-		// Build chunk
-		chunk := &Chunk{
-			StartTime:          chunkSentences[0].StartTime,
-			NumSentences:       len(chunkSentences),
-			SentenceEmbeddings: make([][]float32, len(chunkSentences)),
-			ChunkIndex:         chunkIndex,
-			Embedding:          nil, // handled by EmbedChunks()
+	negInf := float32(math.Inf(-1))
+	dp := make([][]float32, n+1)
+	parent := make([][]int, n+1)
+	for j := range dp {
+		dp[j] = make([]float32, maxK+1)
+		parent[j] = make([]int, maxK+1)
+		for k := range dp[j] {
+			dp[j][k] = negInf
+			parent[j][k] = -1
 		}
+	}
+	dp[0][0] = 0
 
-		tokenCount := 0
-		textParts := make([]string, len(chunkSentences))
-
-		for i, s := range chunkSentences {
-			chunk.SentenceEmbeddings[i] = s.Embedding
-			tokenCount += s.TokenCount
-			textParts[i] = s.Text
+	for j := 1; j <= n; j++ {
+		for i := 0; i < j; i++ {
+			penalty, legal := cfg.ComputePenalty(i, j, prefixTokens)
+			if !legal {
+				continue // Segment too large, skip
+			}
+			segmentScore := reward(i, j) - penalty - cfg.ChunkPenalty
+
+			for k := 1; k <= maxK && k <= j; k++ {
+				if math.IsInf(float64(dp[i][k-1]), -1) {
+					continue // Skip unreachable parents
+				}
+				if score := dp[i][k-1] + segmentScore; score > dp[j][k] {
+					dp[j][k] = score
+					parent[j][k] = i
+				}
+			}
 		}
+	}
 
-		chunk.TokenCount = tokenCount
-		chunk.Text = strings.Join(textParts, " ")
-
-		chunks = append(chunks, chunk)
-		pos = prevPos
-		chunkIndex++
+	// Pick the best feasible chunk count
+	bestK := -1
+	for k := minK; k <= maxK; k++ {
+		if math.IsInf(float64(dp[n][k]), -1) {
+			continue
+		}
+		if bestK == -1 || dp[n][k] > dp[n][bestK] {
+			bestK = k
+		}
+	}
+	if bestK == -1 {
+		return nil, fmt.Errorf("DP failed: no segmentation into %d..%d chunks fits under MaxSize=%d", minK, maxK, cfg.MaxSize)
 	}
 
-	// Reverse chunks since we built them backwards
-	for i := 0; i < len(chunks)/2; i++ {
-		j := len(chunks) - 1 - i
-		chunks[i], chunks[j] = chunks[j], chunks[i]
+	// Flatten the chosen path into a 1D parent array
+	start = make([]int, n+1)
+	for i := range start {
+		start[i] = -1
 	}
+	start[0] = 0
+	for pos, k := n, bestK; pos > 0; k-- {
+		start[pos] = parent[pos][k]
+		pos = start[pos]
+	}
+
+	return start, nil
+}
 
-	// Re-index chunks
-	for i := range chunks {
-		chunks[i].ChunkIndex = i
+// buildPrefixUnitEmbeddings returns prefix sums of unit-normalized sentence embeddings
+// for CentroidReward. Zero vectors contribute nothing.
+func buildPrefixUnitEmbeddings(sentences []*Sentence) ([][]float32, error) {
+	dim := len(sentences[0].Embedding)
+	prefixUnit := make([][]float32, len(sentences)+1)
+	prefixUnit[0] = make([]float32, dim)
+
+	for k, s := range sentences {
+		if len(s.Embedding) != dim {
+			return nil, fmt.Errorf("sentence %d has embedding dim %d, expected %d", k, len(s.Embedding), dim)
+		}
+
+		var sq float64
+		for _, v := range s.Embedding {
+			sq += float64(v) * float64(v)
+		}
+		norm := float32(math.Sqrt(sq))
+
+		prefixUnit[k+1] = make([]float32, dim)
+		for d, v := range s.Embedding {
+			if norm > 0 {
+				prefixUnit[k+1][d] = prefixUnit[k][d] + v/norm
+			} else {
+				prefixUnit[k+1][d] = prefixUnit[k][d]
+			}
+		}
 	}
 
-	return chunks, nil
+	return prefixUnit, nil
+}
+
+// ErrNonFiniteEmbedding is returned for embeddings containing NaN or Inf components
+var ErrNonFiniteEmbedding = errors.New("embedding contains NaN or Inf")
+
+// ValidateEmbedding returns ErrNonFiniteEmbedding, naming the first bad component, if the
+// embedding has a NaN or Inf value. A nil embedding is valid here.
+func ValidateEmbedding(embedding []float32) error {
+	for i, v := range embedding {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return fmt.Errorf("%w: component %d is %v", ErrNonFiniteEmbedding, i, v)
+		}
+	}
+	return nil
 }
 
 // a dot b / norm(a) norm(b)
@@ -257,9 +728,94 @@ func CosineSimilarity(a []float32, b []float32) (float32, error) {
 	if normA == 0 || normB == 0 {
 		return 0, errors.New("divide by zero")
 	}
+	if !isFinite(dotProduct) || !isFinite(normA) || !isFinite(normB) {
+		return 0, ErrNonFiniteEmbedding
+	}
 
 	normA = float32(math.Sqrt(float64(normA)))
 	normB = float32(math.Sqrt(float64(normB)))
 
 	return dotProduct / (normA * normB), nil
 }
+
+// NormalizeL2 returns a copy of v scaled to unit length. For unit vectors, CosineSimilarity
+// equals the dot product. A zero vector is returned unchanged.
+func NormalizeL2(v []float32) []float32 {
+	var sq float64
+	for _, x := range v {
+		sq += float64(x) * float64(x)
+	}
+
+	normalized := make([]float32, len(v))
+	if sq == 0 {
+		copy(normalized, v)
+		return normalized
+	}
+	norm := math.Sqrt(sq)
+	for i, x := range v {
+		normalized[i] = float32(float64(x) / norm)
+	}
+	return normalized
+}
+
+func isFinite(v float32) bool {
+	return !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0)
+}
+
+// CosineSimilarityBatch compares query against every candidate, computing the query norm
+// once. Zero-norm candidates score 0; a zero-norm query or any candidate of a different
+// dimension is an error.
+func CosineSimilarityBatch(query []float32, candidates [][]float32) ([]float32, error) {
+	if len(query) == 0 {
+		return nil, errors.New("empty query vector")
+	}
+
+	var queryNorm float32
+	for _, v := range query {
+		queryNorm += v * v
+	}
+	if queryNorm == 0 {
+		return nil, errors.New("divide by zero")
+	}
+	queryNorm = float32(math.Sqrt(float64(queryNorm)))
+
+	similarities := make([]float32, len(candidates))
+	for i, c := range candidates {
+		if len(c) != len(query) {
+			return nil, fmt.Errorf("candidate %d has dimension %d, query has %d", i, len(c), len(query))
+		}
+
+		var dotProduct, norm float32
+		for j := range c {
+			dotProduct += query[j] * c[j]
+			norm += c[j] * c[j]
+		}
+		if norm == 0 {
+			continue
+		}
+		similarities[i] = dotProduct / (queryNorm * float32(math.Sqrt(float64(norm))))
+	}
+	return similarities, nil
+}
+
+// TopKByCosine returns the indices of the k candidates most similar to query, best first.
+// Ties keep candidate order.
+func TopKByCosine(query []float32, candidates [][]float32, k int) ([]int, error) {
+	similarities, err := CosineSimilarityBatch(query, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	indices := make([]int, len(candidates))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		return similarities[indices[a]] > similarities[indices[b]]
+	})
+
+	if k < len(indices) {
+		indices = indices[:max(k, 0)]
+	}
+	return indices, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestHardSplitOversizedUsesResplit(t *testing.T) {
+	cfg := DefaultChunkingConfig()
+	cfg.MaxSize = 4
+	var resplit []string
+	cfg.Resplit = func(s *Sentence, maxSize int) ([]*Sentence, error) {
+		resplit = append(resplit, s.Text)
+		words := strings.Fields(s.Text)
+		mid := len(words) / 2
+		return []*Sentence{
+			{Text: strings.Join(words[:mid], " "), TokenCount: mid, Embedding: []float32{1, 0}},
+			{Text: strings.Join(words[mid:], " "), TokenCount: len(words) - mid, Embedding: []float32{0, 1}},
+		}, nil
+	}
+
+	sentences := []*Sentence{
+		{Text: "short one", TokenCount: 2, Embedding: []float32{1, 1}},
+		{Text: "a b c d e f", TokenCount: 6, Embedding: []float32{1, 1}},
+	}
+	got, err := cfg.hardSplitOversized(sentences)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resplit) != 1 || resplit[0] != "a b c d e f" {
+		t.Fatalf("Resplit called with %q, want only the oversized sentence", resplit)
+	}
+	if len(got) != 3 || got[0] != sentences[0] {
+		t.Fatalf("got %d sentences, want the short one followed by 2 pieces", len(got))
+	}
+	if got[1].TokenCount != 3 || got[2].TokenCount != 3 || got[2].Embedding[1] != 1 {
+		t.Errorf("pieces should keep Resplit's token counts and embeddings, got %+v %+v", got[1], got[2])
+	}
+}
+
+func TestHardSplitOversizedWithoutResplitEstimates(t *testing.T) {
+	cfg := DefaultChunkingConfig()
+	cfg.MaxSize = 4
+	got, err := cfg.hardSplitOversized([]*Sentence{{Text: "a b c d e f g h", TokenCount: 8, Embedding: []float32{1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) < 2 {
+		t.Fatalf("got %d pieces, want at least 2", len(got))
+	}
+	for _, s := range got {
+		if s.TokenCount > cfg.MaxSize {
+			t.Errorf("piece %q estimated at %d tokens, over MaxSize %d", s.Text, s.TokenCount, cfg.MaxSize)
+		}
+	}
+}
+
+func TestSingleSentenceEmbeddingIsValidated(t *testing.T) {
+	cfg := DefaultChunkingConfig()
+	nan := float32(math.NaN())
+	_, err := cfg.ExtractChunksFromSentences([]*Sentence{{Text: "only", TokenCount: 1, Embedding: []float32{nan}}})
+	if !errors.Is(err, ErrNonFiniteEmbedding) {
+		t.Errorf("err = %v, want ErrNonFiniteEmbedding", err)
+	}
+
+	_, err = cfg.ExtractChunksFromSentences([]*Sentence{{Text: "only", TokenCount: 1}})
+	if err == nil {
+		t.Error("want an error for a sentence without an embedding")
+	}
+}
+
+// sentencesWithEmbeddings returns 3-token sentences with the given embeddings
+func sentencesWithEmbeddings(embeddings ...[]float32) []*Sentence {
+	sentences := make([]*Sentence, len(embeddings))
+	for i, emb := range embeddings {
+		sentences[i] = &Sentence{Text: "one two three", TokenCount: 3, Embedding: emb}
+	}
+	return sentences
+}
+
+func TestSizeForcedSplitContinuesPrevious(t *testing.T) {
+	cfg := DefaultChunkingConfig()
+	cfg.OptimalSize = 6
+	cfg.MaxSize = 6
+	cfg.ContinuationThreshold = 0.9
+
+	// One topic throughout, but only two sentences fit in a chunk
+	same := []float32{1, 0}
+	chunks, err := cfg.ExtractChunksFromSentences(sentencesWithEmbeddings(same, same, same, same))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want a size-forced split", len(chunks))
+	}
+	if chunks[0].ContinuesPrevious {
+		t.Error("first chunk flagged as continuing a previous one")
+	}
+	for i, c := range chunks[1:] {
+		if !c.ContinuesPrevious {
+			t.Errorf("chunk %d split from similar content is not flagged ContinuesPrevious", i+1)
+		}
+	}
+}
+
+func TestTopicChangeDoesNotContinuePrevious(t *testing.T) {
+	cfg := DefaultChunkingConfig()
+	cfg.OptimalSize = 6
+	cfg.MaxSize = 6
+	cfg.ContinuationThreshold = 0.9
+
+	a, b := []float32{1, 0}, []float32{0, 1}
+	chunks, err := cfg.ExtractChunksFromSentences(sentencesWithEmbeddings(a, a, b, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks, want 2", len(chunks))
+	}
+	if chunks[1].ContinuesPrevious {
+		t.Error("chunk after a topic change flagged ContinuesPrevious")
+	}
+}
+
+func TestChunkCountLimits(t *testing.T) {
+	a, b := []float32{1, 0}, []float32{0, 1}
+	tests := []struct {
+		name                 string
+		minChunks, maxChunks int
+		want                 int // chunk count, 0 = error
+	}{
+		{"unconstrained", 0, 0, 3},
+		{"within limits", 2, 4, 3},
+		{"too many", 0, 2, 2},
+		{"too few", 5, 0, 5},
+		{"too few and capped", 4, 5, 4},
+		{"over MaxSize", 0, 1, 0},
+		{"more chunks than sentences", 7, 0, 0},
+		{"min above max", 4, 3, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultChunkingConfig()
+			cfg.OptimalSize = 6
+			cfg.MaxSize = 12
+			cfg.MinChunks = tt.minChunks
+			cfg.MaxChunks = tt.maxChunks
+
+			// Alternating topics, so every sentence change is a topic change
+			chunks, err := cfg.ExtractChunksFromSentences(sentencesWithEmbeddings(a, b, a, b, a, b))
+			if tt.want == 0 {
+				if err == nil {
+					t.Errorf("got %d chunks, want an error", len(chunks))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chunks) != tt.want {
+				t.Errorf("got %d chunks, want %d", len(chunks), tt.want)
+			}
+		})
+	}
+}
+
+func TestSingleOversizedSentenceIsSplit(t *testing.T) {
+	cfg := DefaultChunkingConfig()
+	cfg.OptimalSize = 4
+	cfg.MaxSize = 4
+	chunks, err := cfg.ExtractChunksFromSentences([]*Sentence{
+		{Text: "a b c d e f g h", TokenCount: 8, Embedding: []float32{1, 0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want the sentence split across at least 2", len(chunks))
+	}
+	for i, c := range chunks {
+		if c.TokenCount > cfg.MaxSize {
+			t.Errorf("chunk %d has %d tokens, over MaxSize %d", i, c.TokenCount, cfg.MaxSize)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// TranscriptProcessedEvent is published once a transcript's chunks are stored
+type TranscriptProcessedEvent struct {
+	ClassName  string `json:"class_name"`
+	Professor  string `json:"professor"`
+	Semester   string `json:"semester"`
+	URL        string `json:"url"`
+	ChunkCount int    `json:"chunk_count"`
+	ModelName  string `json:"model_name"`
+}
+
+// CompletionPublisher produces TranscriptProcessedEvents to the completion topic.
+// A nil publisher (no topic configured) publishes nothing.
+type CompletionPublisher struct {
+	producer *kafka.Producer
+	topic    string
+}
+
+// completionDeliveryTimeout bounds how long Publish waits for the broker to ack
+const completionDeliveryTimeout = 10 * time.Second
+
+// NewCompletionPublisher creates a producer for kafkaConfig.CompletionTopic, or returns
+// nil if the topic is empty
+func NewCompletionPublisher(kafkaConfig *KafkaConfig) (*CompletionPublisher, error) {
+	if kafkaConfig.CompletionTopic == "" {
+		return nil, nil
+	}
+
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": kafkaConfig.BootstrapServers,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+
+	return &CompletionPublisher{
+		producer: producer,
+		topic:    kafkaConfig.CompletionTopic,
+	}, nil
+}
+
+// Publish sends event keyed by URL and waits for the delivery report
+func (p *CompletionPublisher) Publish(event TranscriptProcessedEvent) error {
+	if p == nil {
+		return nil
+	}
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal completion event: %w", err)
+	}
+
+	delivery := make(chan kafka.Event, 1)
+	err = p.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &p.topic, Partition: kafka.PartitionAny},
+		Key:            []byte(event.URL),
+		Value:          value,
+	}, delivery)
+	if err != nil {
+		return fmt.Errorf("failed to produce completion event: %w", err)
+	}
+
+	select {
+	case ev := <-delivery:
+		if msg, ok := ev.(*kafka.Message); ok && msg.TopicPartition.Error != nil {
+			return fmt.Errorf("completion event delivery failed: %w", msg.TopicPartition.Error)
+		}
+		return nil
+	case <-time.After(completionDeliveryTimeout):
+		return fmt.Errorf("completion event not acknowledged within %v", completionDeliveryTimeout)
+	}
+}
+
+// Close flushes outstanding events and closes the producer
+func (p *CompletionPublisher) Close() {
+	if p == nil {
+		return
+	}
+	p.producer.Flush(int(completionDeliveryTimeout.Milliseconds()))
+	p.producer.Close()
+}
@@ -1,44 +1,131 @@
 package main
 
 import (
+	"log/slog"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"piazza-bot/shared/cassandra"
 )
 
 // Config holds configuration for the processor
 type CassandraConfig struct {
 	CassandraHosts    []string
 	CassandraKeyspace string
+	InsertMaxAttempts int           // Attempts per insert on retryable errors (write timeout, unavailable)
+	InsertBaseBackoff time.Duration // Backoff before the first retry, doubled on each attempt
+	InsertBatchSize   int           // Max rows per UNLOGGED batch when inserting chunks (1 disables batching)
+	OperationTimeout  time.Duration // Deadline for one store operation, retries included (0 disables)
+
+	Options cassandra.Options // Password authentication and TLS
 }
 
 // KafkaConfig holds Kafka consumer configuration
 type KafkaConfig struct {
 	BootstrapServers string
-	Topic            string
+	Topics           []string // Topics to consume; entries starting with "^" are regex patterns
 	GroupID          string
+	AutoOffsetReset  string // Where a group with no committed offset starts: "earliest" or "latest"
+	EnableAutoCommit bool   // If false, offsets are committed only after a message is processed successfully
+
+	PollTimeout time.Duration // Max time Poll blocks waiting for a message, bounds how long a signal goes unnoticed
+
+	ReconnectBackoff    time.Duration // Wait before recreating the consumer after all brokers went down
+	ReconnectMaxBackoff time.Duration // Cap on the reconnect wait, which doubles while Kafka stays down
+
+	RetryBackoff time.Duration // Wait before a failed message's partition is polled again
+	MaxRetries   int           // Redeliveries of a transiently failing message before it is committed anyway (0 = no limit)
+
+	PriorityTopic      string // Optional second topic whose events are treated as at least priority 1
+	PriorityBufferSize int    // Ready messages buffered to pick the highest priority from (1 = plain FIFO)
+
+	CompletionTopic string // Topic for transcript-processed events, empty disables them
+}
+
+// Segment scoring modes for ChunkingConfig.ScoringMode
+const (
+	ScoringModeAdjacent = "adjacent" // sum of adjacent-sentence similarities, O(1) per segment
+	ScoringModeCentroid = "centroid" // mean similarity to segment centroid, O(dim) per segment
+)
+
+// Chunking algorithms for ChunkingConfig.Algorithm
+const (
+	ChunkingAlgorithmDP     = "dp"     // ExtractChunksFromSentences, globally optimal boundaries
+	ChunkingAlgorithmGreedy = "greedy" // GreedyChunk, single pass for quick ingestion
+)
+
+// ProcessorConfig holds options for how transcript events are processed
+type ProcessorConfig struct {
+	NormalizeLectureOrder bool // Derive lecture order from the title when lecture_number is missing
+	KeywordsPerChunk      int  // Top TF-IDF keywords stored per chunk (0 disables)
+	DetectPlainText       bool // Treat transcripts without any "-->" lines as untimed plain text
+	MergeDuplicateFrames  bool // Drop frames repeating the previous frame's text (rolling captions)
+
+	SpeakerPatterns []*regexp.Regexp // Leading speaker labels stripped from frames, nil disables
+	ReprocessMode   bool             // Delete a URL's existing chunks before inserting the new ones
+
+	StoreSentenceEmbeddings bool // Also write each sentence's embedding to sentence_embeddings
+
+	ShutdownTimeout time.Duration // Max time to close the consumer, model, and session on shutdown
+
+	HealthAddr      string        // Listen address for /healthz and /readyz, empty disables
+	LivenessTimeout time.Duration // /healthz fails if the main loop hasn't ticked within this
 }
 
 // ChunkingConfig holds all tunable parameters for the semantic chunking algorithm
 type ChunkingConfig struct {
-	OptimalSize  int     // optimal chunk size, no penalty below this (default: 470)
-	MaxSize      int     // chunk size hard limit, infinite penalty at or above (default: 512)
-	LambdaSize   float32 // Max penalty in "edge units" at MaxSize (default: 3.0)
-	ChunkPenalty float32 // Initial penalty per chunk to discourage small chunks (default: 1.0)
+	OptimalSize           int     // optimal chunk size, no penalty below this (default: 470)
+	MaxSize               int     // chunk size hard limit, infinite penalty at or above (default: 512)
+	LambdaSize            float32 // Max penalty in "edge units" at MaxSize (default: 3.0)
+	ChunkPenalty          float32 // Initial penalty per chunk to discourage small chunks (default: 1.0)
+	ScoringMode           string  // ScoringModeAdjacent or ScoringModeCentroid (default: adjacent)
+	OverlapSentences      int     // Trailing sentences of the previous chunk prepended to each chunk after the DP (default: 0)
+	MinChunks             int     // Minimum number of chunks, 0 = unbounded (default: 0)
+	MaxChunks             int     // Maximum number of chunks, 0 = unbounded (default: 0)
+	ContinuationThreshold float32 // Boundary similarity at or above which a chunk is flagged ContinuesPrevious (default: 0, disabled)
+	Algorithm             string  // ChunkingAlgorithmDP or ChunkingAlgorithmGreedy (default: dp)
+	GreedyThreshold       float32 // Greedy mode: adjacent similarity below which a new chunk starts (default: 0.5)
+
+	// Resplit splits a sentence over MaxSize into pieces with their own token counts and
+	// embeddings (see EmbeddingModel.ResplitSentence). If nil, hard-split pieces get
+	// estimated token counts and share the original sentence's embedding.
+	Resplit func(s *Sentence, maxSize int) ([]*Sentence, error)
 }
 
 // EmbeddingConfig holds embedding model configuration
 type EmbeddingConfig struct {
-	MaxBatchTokens int // Max total tokens per batch (controls GPU memory usage)
+	MaxBatchTokens  int     // Max total tokens per batch (controls GPU memory usage)
+	MaxBatchSize    int     // Max texts per batch, for runtimes with a fixed batch dimension (0 = unlimited)
+	StatsSampleRate float64 // Fraction of lectures whose chunk embedding stats are logged (0 disables)
+	SentenceCache   bool    // Reuse sentence embeddings from the previous run of a lecture
+	DedupTexts      bool    // Embed repeated texts (e.g. "Okay.") once per call and share the result
+	MemoryCacheSize int     // Embeddings kept in an in-process LRU keyed by text hash (0 disables)
+	QueryPrefix     string  // Instruction prefix for search queries, e.g. "query: " (must match the search side)
+	PassagePrefix   string  // Instruction prefix for stored chunks, e.g. "passage: "
+	ModelName       string  // Stored with each chunk so vectors from different models are never compared
+	MaxQueryTokens  int     // Query token limit, prefix included (matches ChunkingConfig.MaxSize by default)
+	StrictQuery     bool    // Reject over-long queries instead of truncating them
+	Normalize       bool    // L2-normalize stored chunk and search query embeddings to unit length
+
+	ModelPath          string // ONNX model file
+	TokenizerPath      string // HuggingFace tokenizer.json
+	OnnxRuntimeLibPath string // ONNX Runtime shared library
+
+	InputNames []string // Model inputs to feed, in order; any the model doesn't declare are skipped
+	OutputName string   // Model output holding the token embeddings
+	// Token whose output vector is the embedding (0 = [CLS]). Ignored for outputs that
+	// are already pooled, shaped [batch, hidden].
+	PoolingTokenIndex int
 }
 
 // cassandra config
 func LoadCassandraConfig() *CassandraConfig {
-	cassandraHostsStr := os.Getenv("CASSANDRA_HOSTS")
-	var cassandraHosts []string
-	if cassandraHostsStr == "" {
+	cassandraHosts := cassandra.SplitHosts(os.Getenv("CASSANDRA_HOSTS"))
+	if len(cassandraHosts) == 0 {
 		cassandraHosts = []string{"db-1", "db-2", "db-3"}
-	} else {
-		cassandraHosts = strings.Split(cassandraHostsStr, ",")
 	}
 
 	cassandraKeyspace := os.Getenv("CASSANDRA_KEYSPACE")
@@ -46,9 +133,35 @@ func LoadCassandraConfig() *CassandraConfig {
 		cassandraKeyspace = "transcript_db"
 	}
 
+	insertMaxAttempts := 5
+	if v, err := strconv.Atoi(os.Getenv("CASSANDRA_INSERT_MAX_ATTEMPTS")); err == nil && v > 0 {
+		insertMaxAttempts = v
+	}
+
+	insertBaseBackoff := 200 * time.Millisecond
+	if v, err := time.ParseDuration(os.Getenv("CASSANDRA_INSERT_BASE_BACKOFF")); err == nil {
+		insertBaseBackoff = v
+	}
+
+	insertBatchSize := 20
+	if v, err := strconv.Atoi(os.Getenv("CASSANDRA_INSERT_BATCH_SIZE")); err == nil && v > 0 {
+		insertBatchSize = v
+	}
+
+	operationTimeout := 2 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("CASSANDRA_OPERATION_TIMEOUT")); err == nil && v >= 0 {
+		operationTimeout = v
+	}
+
 	return &CassandraConfig{
 		CassandraHosts:    cassandraHosts,
 		CassandraKeyspace: cassandraKeyspace,
+		InsertMaxAttempts: insertMaxAttempts,
+		InsertBaseBackoff: insertBaseBackoff,
+		InsertBatchSize:   insertBatchSize,
+		OperationTimeout:  operationTimeout,
+
+		Options: cassandra.OptionsFromEnv(),
 	}
 }
 
@@ -59,31 +172,293 @@ func LoadKafkaConfig() *KafkaConfig {
 		bootstrapServers = "kafka:9092"
 	}
 
-	topic := os.Getenv("KAFKA_TOPIC")
-	if topic == "" {
-		topic = "transcript-events"
+	// Comma-separated, e.g. KAFKA_TOPIC=uw-transcripts,^transcripts-.*
+	var topics []string
+	for _, topic := range strings.Split(os.Getenv("KAFKA_TOPIC"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	if len(topics) == 0 {
+		topics = []string{"transcript-events"}
+	}
+
+	// Separate deployments (e.g. a reindex job) need their own group
+	groupID := os.Getenv("KAFKA_GROUP_ID")
+	if groupID == "" {
+		groupID = "processor-group"
+	}
+
+	autoOffsetReset := "earliest"
+	if v := os.Getenv("KAFKA_AUTO_OFFSET_RESET"); v != "" {
+		autoOffsetReset = v
+	}
+
+	enableAutoCommit := false
+	if v, err := strconv.ParseBool(os.Getenv("KAFKA_ENABLE_AUTO_COMMIT")); err == nil {
+		enableAutoCommit = v
 	}
 
+	pollTimeout := 500 * time.Millisecond
+	if v, err := time.ParseDuration(os.Getenv("KAFKA_POLL_TIMEOUT")); err == nil && v > 0 {
+		pollTimeout = v
+	}
+
+	reconnectBackoff := time.Second
+	if v, err := time.ParseDuration(os.Getenv("KAFKA_RECONNECT_BACKOFF")); err == nil && v > 0 {
+		reconnectBackoff = v
+	}
+	reconnectMaxBackoff := time.Minute
+	if v, err := time.ParseDuration(os.Getenv("KAFKA_RECONNECT_MAX_BACKOFF")); err == nil && v > 0 {
+		reconnectMaxBackoff = v
+	}
+	reconnectMaxBackoff = max(reconnectMaxBackoff, reconnectBackoff)
+
+	retryBackoff := 5 * time.Second
+	if v, err := time.ParseDuration(os.Getenv("KAFKA_RETRY_BACKOFF")); err == nil && v >= 0 {
+		retryBackoff = v
+	}
+
+	maxRetries := 10
+	if v, err := strconv.Atoi(os.Getenv("KAFKA_MAX_RETRIES")); err == nil && v >= 0 {
+		maxRetries = v
+	}
+
+	priorityTopic := os.Getenv("KAFKA_PRIORITY_TOPIC")
+
+	priorityBufferSize := 1
+	if v, err := strconv.Atoi(os.Getenv("KAFKA_PRIORITY_BUFFER_SIZE")); err == nil && v > 0 {
+		priorityBufferSize = v
+	}
+
+	// e.g. KAFKA_COMPLETION_TOPIC=transcript-processed
+	completionTopic := os.Getenv("KAFKA_COMPLETION_TOPIC")
+
 	return &KafkaConfig{
 		BootstrapServers: bootstrapServers,
-		Topic:            topic,
-		GroupID:          "processor-group",
+		Topics:           topics,
+		GroupID:          groupID,
+		AutoOffsetReset:  autoOffsetReset,
+		EnableAutoCommit: enableAutoCommit,
+
+		PollTimeout: pollTimeout,
+
+		ReconnectBackoff:    reconnectBackoff,
+		ReconnectMaxBackoff: reconnectMaxBackoff,
+
+		RetryBackoff: retryBackoff,
+		MaxRetries:   maxRetries,
+
+		PriorityTopic:      priorityTopic,
+		PriorityBufferSize: priorityBufferSize,
+
+		CompletionTopic: completionTopic,
+	}
+}
+
+// LoadProcessorConfig loads processing options from environment variables
+func LoadProcessorConfig() *ProcessorConfig {
+	normalizeLectureOrder := true
+	if v, err := strconv.ParseBool(os.Getenv("NORMALIZE_LECTURE_ORDER")); err == nil {
+		normalizeLectureOrder = v
+	}
+
+	keywordsPerChunk := 0
+	if v, err := strconv.Atoi(os.Getenv("KEYWORDS_PER_CHUNK")); err == nil && v > 0 {
+		keywordsPerChunk = v
+	}
+
+	detectPlainText := true
+	if v, err := strconv.ParseBool(os.Getenv("DETECT_PLAIN_TEXT")); err == nil {
+		detectPlainText = v
+	}
+
+	mergeDuplicateFrames, _ := strconv.ParseBool(os.Getenv("MERGE_DUPLICATE_FRAMES"))
+
+	// STRIP_SPEAKER_LABELS uses DefaultSpeakerPatterns; SPEAKER_LABEL_PATTERN replaces them
+	// with a single (anchored) regex, combine alternatives with "|"
+	var speakerPatterns []*regexp.Regexp
+	if v, _ := strconv.ParseBool(os.Getenv("STRIP_SPEAKER_LABELS")); v {
+		speakerPatterns = DefaultSpeakerPatterns
+		if pattern := os.Getenv("SPEAKER_LABEL_PATTERN"); pattern != "" {
+			if re, err := regexp.Compile(pattern); err == nil {
+				speakerPatterns = []*regexp.Regexp{re}
+			} else {
+				slog.Warn("Invalid SPEAKER_LABEL_PATTERN, using defaults", "error", err)
+			}
+		}
+	}
+
+	reprocessMode := true
+	if v, err := strconv.ParseBool(os.Getenv("REPROCESS_MODE")); err == nil {
+		reprocessMode = v
+	}
+
+	storeSentenceEmbeddings := false
+	if v, err := strconv.ParseBool(os.Getenv("STORE_SENTENCE_EMBEDDINGS")); err == nil {
+		storeSentenceEmbeddings = v
+	}
+
+	shutdownTimeout := 30 * time.Second
+	if v, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && v > 0 {
+		shutdownTimeout = v
+	}
+
+	healthAddr := ":8080"
+	if v, ok := os.LookupEnv("HEALTH_ADDR"); ok {
+		healthAddr = v
+	}
+
+	livenessTimeout := 15 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("LIVENESS_TIMEOUT")); err == nil && v > 0 {
+		livenessTimeout = v
+	}
+
+	return &ProcessorConfig{
+		NormalizeLectureOrder:   normalizeLectureOrder,
+		KeywordsPerChunk:        keywordsPerChunk,
+		DetectPlainText:         detectPlainText,
+		MergeDuplicateFrames:    mergeDuplicateFrames,
+		SpeakerPatterns:         speakerPatterns,
+		ReprocessMode:           reprocessMode,
+		StoreSentenceEmbeddings: storeSentenceEmbeddings,
+		ShutdownTimeout:         shutdownTimeout,
+		HealthAddr:              healthAddr,
+		LivenessTimeout:         livenessTimeout,
 	}
 }
 
 // DefaultEmbeddingConfig returns sensible defaults for embedding
 func DefaultEmbeddingConfig() EmbeddingConfig {
 	return EmbeddingConfig{
-		MaxBatchTokens: 6000,
+		MaxBatchTokens:  6000,
+		MaxBatchSize:    0,
+		StatsSampleRate: 0,
+		SentenceCache:   false,
+		DedupTexts:      false,
+		MemoryCacheSize: 0,
+		QueryPrefix:     "",
+		PassagePrefix:   "",
+		ModelName:       "thenlper/gte-large",
+		MaxQueryTokens:  DefaultChunkingConfig().MaxSize,
+		StrictQuery:     false,
+		Normalize:       false,
+
+		ModelPath:          "./model.onnx",
+		TokenizerPath:      "./tokenizer.json",
+		OnnxRuntimeLibPath: "/usr/local/lib/libonnxruntime.so.1.23.2", // inside docker container
+
+		InputNames: []string{"input_ids", "attention_mask", "token_type_ids"},
+		OutputName: "last_hidden_state",
+
+		PoolingTokenIndex: 0,
 	}
 }
 
+// LoadEmbeddingConfig applies environment overrides on top of DefaultEmbeddingConfig
+func LoadEmbeddingConfig() EmbeddingConfig {
+	config := DefaultEmbeddingConfig()
+
+	if v, err := strconv.Atoi(os.Getenv("EMBEDDING_MAX_BATCH_SIZE")); err == nil && v >= 0 {
+		config.MaxBatchSize = v
+	}
+
+	if v, err := strconv.ParseFloat(os.Getenv("EMBEDDING_STATS_SAMPLE_RATE"), 64); err == nil {
+		config.StatsSampleRate = v
+	}
+
+	if v, err := strconv.ParseBool(os.Getenv("EMBEDDING_SENTENCE_CACHE")); err == nil {
+		config.SentenceCache = v
+	}
+
+	if v, err := strconv.ParseBool(os.Getenv("EMBEDDING_DEDUP_TEXTS")); err == nil {
+		config.DedupTexts = v
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("EMBEDDING_MEMORY_CACHE_SIZE")); err == nil && v >= 0 {
+		config.MemoryCacheSize = v
+	}
+
+	if v, ok := os.LookupEnv("EMBEDDING_QUERY_PREFIX"); ok {
+		config.QueryPrefix = v
+	}
+	if v, ok := os.LookupEnv("EMBEDDING_PASSAGE_PREFIX"); ok {
+		config.PassagePrefix = v
+	}
+
+	if v := os.Getenv("EMBEDDING_MODEL_NAME"); v != "" {
+		config.ModelName = v
+	}
+
+	if v := os.Getenv("EMBEDDING_MODEL_PATH"); v != "" {
+		config.ModelPath = v
+	}
+	if v := os.Getenv("EMBEDDING_TOKENIZER_PATH"); v != "" {
+		config.TokenizerPath = v
+	}
+	if v := os.Getenv("ONNXRUNTIME_LIB_PATH"); v != "" {
+		config.OnnxRuntimeLibPath = v
+	}
+
+	// e.g. EMBEDDING_INPUT_NAMES=input_ids,attention_mask for exports without token_type_ids
+	if v := os.Getenv("EMBEDDING_INPUT_NAMES"); v != "" {
+		var names []string
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			config.InputNames = names
+		}
+	}
+	if v := os.Getenv("EMBEDDING_OUTPUT_NAME"); v != "" {
+		config.OutputName = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("EMBEDDING_POOLING_TOKEN_INDEX")); err == nil && v >= 0 {
+		config.PoolingTokenIndex = v
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("EMBEDDING_MAX_QUERY_TOKENS")); err == nil && v > 0 {
+		config.MaxQueryTokens = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("EMBEDDING_STRICT_QUERY")); err == nil {
+		config.StrictQuery = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("EMBEDDING_NORMALIZE")); err == nil {
+		config.Normalize = v
+	}
+
+	return config
+}
+
 // DefaultChunkingConfig returns sensible defaults
 func DefaultChunkingConfig() ChunkingConfig {
 	return ChunkingConfig{
-		OptimalSize:  470,
-		MaxSize:      512,
-		LambdaSize:   2.0,
-		ChunkPenalty: 1.0,
+		OptimalSize:           470,
+		MaxSize:               512,
+		LambdaSize:            2.0,
+		ChunkPenalty:          1.0,
+		ScoringMode:           ScoringModeAdjacent,
+		OverlapSentences:      0,
+		MinChunks:             0,
+		MaxChunks:             0,
+		ContinuationThreshold: 0,
+		Algorithm:             ChunkingAlgorithmDP,
+		GreedyThreshold:       0.5,
 	}
 }
+
+// LoadChunkingConfig applies environment overrides on top of DefaultChunkingConfig
+func LoadChunkingConfig() ChunkingConfig {
+	config := DefaultChunkingConfig()
+
+	if v := os.Getenv("CHUNKING_ALGORITHM"); v != "" {
+		config.Algorithm = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("CHUNKING_GREEDY_THRESHOLD"), 32); err == nil {
+		config.GreedyThreshold = float32(v)
+	}
+
+	return config
+}
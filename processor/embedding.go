@@ -1,8 +1,18 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"path/filepath"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 
 	tokenizer "github.com/sugarme/tokenizer"
 	"github.com/sugarme/tokenizer/pretrained"
@@ -14,87 +24,233 @@ type EmbeddingModel struct {
 	Tokenizer *tokenizer.Tokenizer
 	session   *ort.DynamicAdvancedSession
 	config    EmbeddingConfig
+
+	inputNames []string // inputs the session was created with, in Run order
+	usingCUDA  bool     // session runs on the GPU; cleared after falling back to CPU
+
+	batchTokenLimit int // effective MaxBatchTokens, lowered when a batch runs out of memory
+
+	cache *MemoryEmbeddingCache // LRU of embeddings across calls, nil if disabled
+
+	embedFn func(texts []string) ([][]float32, error) // replaces the ONNX session in tests
+	runFn   func(inputs, outputs []ort.Value) error   // replaces session.Run in tests
+
+	mu sync.Mutex // serializes model calls, which swap the session and adjust batchTokenLimit
 }
 
 // InitEmbeddingModel loads the ONNX model and tokenizer
 func InitEmbeddingModel(config EmbeddingConfig) (*EmbeddingModel, error) {
+	// Fail early with the offending path rather than deep inside the ORT call
+	for _, file := range []struct{ desc, path string }{
+		{"tokenizer", config.TokenizerPath},
+		{"ONNX model", config.ModelPath},
+		{"ONNX Runtime library", config.OnnxRuntimeLibPath},
+	} {
+		if _, err := os.Stat(file.path); err != nil {
+			return nil, fmt.Errorf("%s not found at %q: %w", file.desc, file.path, err)
+		}
+	}
+
 	// Load tokenizer
-	tokenizerPath := filepath.Join(".", "tokenizer.json")
-	tok, err := pretrained.FromFile(tokenizerPath)
+	tok, err := pretrained.FromFile(config.TokenizerPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load tokenizer: %w", err)
 	}
 
-	// inside docker container
-	ort.SetSharedLibraryPath("/usr/local/lib/libonnxruntime.so.1.23.2")
+	ort.SetSharedLibraryPath(config.OnnxRuntimeLibPath)
 
 	err = ort.InitializeEnvironment()
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize ONNX environment: %w", err)
 	}
 
+	inputNames, err := declaredInputNames(config)
+	if err != nil {
+		return nil, err
+	}
+
+	session, usingCUDA, err := newSession(config, inputNames, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmbeddingModel{
+		Tokenizer:  tok,
+		session:    session,
+		config:     config,
+		inputNames: inputNames,
+		usingCUDA:  usingCUDA,
+
+		batchTokenLimit: config.MaxBatchTokens,
+
+		cache: NewMemoryEmbeddingCache(config.MemoryCacheSize),
+	}, nil
+}
+
+// newSession creates an inference session for the model, trying the CUDA provider first
+// when tryCUDA is set. usingCUDA reports whether the CUDA provider was actually enabled.
+func newSession(config EmbeddingConfig, inputNames []string, tryCUDA bool) (session *ort.DynamicAdvancedSession, usingCUDA bool, err error) {
 	opts, err := ort.NewSessionOptions()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create session options: %w", err)
+		return nil, false, fmt.Errorf("failed to create session options: %w", err)
 	}
 	defer opts.Destroy()
 
 	err = opts.SetGraphOptimizationLevel(ort.GraphOptimizationLevelEnableAll)
 	if err != nil {
-		return nil, fmt.Errorf("failed to set graph optimization: %w", err)
+		return nil, false, fmt.Errorf("failed to set graph optimization: %w", err)
 	}
 
 	// Try to enable CUDA
-	cudaOpts, err := ort.NewCUDAProviderOptions()
-	if err == nil {
-		fmt.Println("CUDA provider options created successfully")
-		// Configure CUDA options and append to opts
-		err = cudaOpts.Update(map[string]string{
-			"device_id": "0", // Use GPU 0
-		})
-		if err == nil {
-			fmt.Println("CUDA options updated successfully")
-			err = opts.AppendExecutionProviderCUDA(cudaOpts)
-			if err == nil {
-				fmt.Println("CUDA execution provider enabled (using GPU)")
-			} else {
-				fmt.Printf("Failed to append CUDA provider: %v\n", err)
-			}
-		} else {
-			fmt.Printf("Failed to update CUDA options: %v\n", err)
-		}
-		cudaOpts.Destroy()
-	} else {
-		fmt.Printf("CUDA not available, using CPU: %v\n", err)
+	if tryCUDA {
+		usingCUDA = appendCUDAProvider(opts)
 	}
 
 	// Otherwise, use CPU
 	err = opts.SetIntraOpNumThreads(0) // 0 = use all available
 	if err != nil {
-		fmt.Printf("Warning: Failed to set thread count: %v\n", err)
+		slog.Warn("Failed to set thread count", "error", err)
 	}
 
 	// Load ONNX model
-	modelPath := filepath.Join(".", "model.onnx")
-
-	session, err := ort.NewDynamicAdvancedSession(
-		modelPath,
-		[]string{"input_ids", "attention_mask", "token_type_ids"}, // Input names
-		[]string{"last_hidden_state"},                             // Output names
+	session, err = ort.NewDynamicAdvancedSession(
+		config.ModelPath,
+		inputNames,
+		[]string{config.OutputName},
 		opts,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create session: %w", err)
+		return nil, false, fmt.Errorf("failed to create session: %w", err)
 	}
+	return session, usingCUDA, nil
+}
 
-	return &EmbeddingModel{
-		Tokenizer: tok,
-		session:   session,
-		config:    config,
-	}, nil
+// appendCUDAProvider adds the CUDA execution provider to opts, reporting whether it was enabled
+func appendCUDAProvider(opts *ort.SessionOptions) bool {
+	cudaOpts, err := ort.NewCUDAProviderOptions()
+	if err != nil {
+		slog.Info("CUDA not available, using CPU", "error", err)
+		return false
+	}
+	defer cudaOpts.Destroy()
+	slog.Info("CUDA provider options created successfully")
+
+	// Configure CUDA options and append to opts
+	err = cudaOpts.Update(map[string]string{
+		"device_id": "0", // Use GPU 0
+	})
+	if err != nil {
+		slog.Warn("Failed to update CUDA options", "error", err)
+		return false
+	}
+	slog.Info("CUDA options updated successfully")
+
+	if err := opts.AppendExecutionProviderCUDA(cudaOpts); err != nil {
+		slog.Warn("Failed to append CUDA provider", "error", err)
+		return false
+	}
+	slog.Info("CUDA execution provider enabled (using GPU)")
+	return true
 }
 
-// EmbedSentences embeds a slice of Sentence structs
+// isCUDAError reports whether an inference error came from the CUDA provider (e.g. GPU OOM)
+func isCUDAError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, marker := range []string{"cuda", "cudnn", "cublas"} {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackToCPU replaces a CUDA session with a CPU-only one
+func (em *EmbeddingModel) fallbackToCPU() error {
+	session, _, err := newSession(em.config, em.inputNames, false)
+	if err != nil {
+		return fmt.Errorf("failed to create CPU session: %w", err)
+	}
+	em.session.Destroy()
+	em.session = session
+	em.usingCUDA = false
+	return nil
+}
+
+// isOOMError reports whether an inference error was a failed memory allocation
+func isOOMError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, marker := range []string{"out of memory", "failed to allocate", "bad_alloc", "memoryallocation"} {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// run runs inference, and if it fails on the GPU, rebuilds the session on CPU once and retries.
+// An OOM on a multi-text batch is returned as is so embedBatches can split the batch first.
+func (em *EmbeddingModel) run(inputs, outputs []ort.Value, batchSize int) error {
+	if em.runFn != nil {
+		return em.runFn(inputs, outputs)
+	}
+
+	err := em.session.Run(inputs, outputs)
+	if err == nil || !em.usingCUDA || !isCUDAError(err) || (batchSize > 1 && isOOMError(err)) {
+		return err
+	}
+
+	slog.Warn("CUDA inference failed, falling back to CPU", "error", err)
+	if fallbackErr := em.fallbackToCPU(); fallbackErr != nil {
+		return fmt.Errorf("%w (CPU fallback failed: %v)", err, fallbackErr)
+	}
+	return em.session.Run(inputs, outputs)
+}
+
+// supportedInputs are the model inputs embedBatch knows how to build
+var supportedInputs = map[string]bool{
+	"input_ids":      true,
+	"attention_mask": true,
+	"token_type_ids": true,
+}
+
+// declaredInputNames returns the configured input names the model actually declares,
+// e.g. dropping token_type_ids for exports that don't take it
+func declaredInputNames(config EmbeddingConfig) ([]string, error) {
+	inputs, outputs, err := ort.GetInputOutputInfo(config.ModelPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model inputs: %w", err)
+	}
+
+	declared := make(map[string]bool, len(inputs))
+	for _, info := range inputs {
+		declared[info.Name] = true
+	}
+
+	var names []string
+	for _, name := range config.InputNames {
+		if !supportedInputs[name] {
+			return nil, fmt.Errorf("unsupported model input %q (supported: input_ids, attention_mask, token_type_ids)", name)
+		}
+		if !declared[name] {
+			slog.Info("Model does not declare input, skipping it", "input", name)
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("model declares none of the configured inputs %v", config.InputNames)
+	}
+
+	for _, info := range outputs {
+		if info.Name == config.OutputName {
+			return names, nil
+		}
+	}
+	return nil, fmt.Errorf("model does not declare output %q", config.OutputName)
+}
+
+// EmbedSentences embeds a slice of Sentence structs. Sentences go to the in-process
+// ONNX session in token-budgeted batches (see embedBatches), not one call per sentence.
 func (em *EmbeddingModel) EmbedSentences(sentences []*Sentence) error {
 	if len(sentences) == 0 {
 		return nil
@@ -107,7 +263,7 @@ func (em *EmbeddingModel) EmbedSentences(sentences []*Sentence) error {
 		tokenCounts[i] = s.TokenCount
 	}
 
-	embeddings, err := em.embedBatches(texts, tokenCounts)
+	embeddings, err := em.embedTexts(texts, tokenCounts)
 	if err != nil {
 		return err
 	}
@@ -118,31 +274,255 @@ func (em *EmbeddingModel) EmbedSentences(sentences []*Sentence) error {
 	return nil
 }
 
-// EmbedChunks embeds a slice of Chunk structs (updates Embedding field in place)
+// EmbeddingCache stores embeddings keyed by sentenceCacheKey so unchanged text can skip
+// the model
+type EmbeddingCache interface {
+	Lookup(ctx context.Context, hashes []string) (map[string][]float32, error)
+	Store(ctx context.Context, entries map[string][]float32) error
+}
+
+// TextHash returns the cache key for a piece of text
+func TextHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// sentenceCacheKey returns the persistent cache key for a sentence. The model name and
+// passage prefix are part of the key, so switching either one misses instead of
+// returning vectors from another embedding space.
+func (em *EmbeddingModel) sentenceCacheKey(text string) string {
+	return TextHash(em.config.ModelName + "\x00" + em.config.PassagePrefix + "\x00" + text)
+}
+
+// EmbedSentencesCached embeds sentences, reusing cached embeddings for unchanged text
+// and only sending misses to the model. Cache errors fall back to embedding everything.
+func (em *EmbeddingModel) EmbedSentencesCached(ctx context.Context, sentences []*Sentence, cache EmbeddingCache) (hits int, err error) {
+	if len(sentences) == 0 {
+		return 0, nil
+	}
+
+	hashes := make([]string, len(sentences))
+	for i, s := range sentences {
+		hashes[i] = em.sentenceCacheKey(s.Text)
+	}
+
+	cached, err := cache.Lookup(ctx, hashes)
+	if err != nil {
+		slog.Warn("Embedding cache lookup failed", "error", err)
+		cached = nil
+	}
+
+	// Collect misses
+	var misses []*Sentence
+	for i, s := range sentences {
+		if emb, ok := cached[hashes[i]]; ok {
+			s.Embedding = emb
+			hits++
+		} else {
+			misses = append(misses, s)
+		}
+	}
+
+	if err := em.EmbedSentences(misses); err != nil {
+		return hits, err
+	}
+
+	// Write back new embeddings
+	if len(misses) > 0 {
+		entries := make(map[string][]float32, len(misses))
+		for _, s := range misses {
+			entries[em.sentenceCacheKey(s.Text)] = s.Embedding
+		}
+		if err := cache.Store(ctx, entries); err != nil {
+			slog.Warn("Embedding cache store failed", "error", err)
+		}
+	}
+
+	return hits, nil
+}
+
+// EmbedChunks embeds a slice of Chunk structs (updates Embedding field in place).
+// PassagePrefix is prepended before tokenization; chunk.Text is left un-prefixed.
+// With Normalize set, the embeddings are scaled to unit length.
+// TokenCount is recounted from chunk.Text, since the per-sentence sums it starts from
+// don't always match the tokenization of the joined text actually embedded.
 func (em *EmbeddingModel) EmbedChunks(chunks []*Chunk) error {
 	if len(chunks) == 0 {
 		return nil
 	}
 
+	prefixTokens := 0
+	if em.config.PassagePrefix != "" {
+		prefixTokens = CountTokens(em.Tokenizer, em.config.PassagePrefix)
+	}
+
 	texts := make([]string, len(chunks))
 	tokenCounts := make([]int, len(chunks))
 	for i, c := range chunks {
-		texts[i] = c.Text
-		tokenCounts[i] = c.TokenCount
+		c.TokenCount = CountTokens(em.Tokenizer, c.Text)
+		texts[i] = em.config.PassagePrefix + c.Text
+		tokenCounts[i] = c.TokenCount + prefixTokens
 	}
 
-	embeddings, err := em.embedBatches(texts, tokenCounts)
+	embeddings, err := em.embedTexts(texts, tokenCounts)
 	if err != nil {
 		return err
 	}
 
 	for i, emb := range embeddings {
+		if em.config.Normalize {
+			emb = NormalizeL2(emb)
+		}
 		chunks[i].Embedding = emb
 	}
 	return nil
 }
 
-// embedBatches processes texts in multiple batches
+// ErrQueryTooLong is returned by PrepareQuery in strict mode when a query exceeds MaxQueryTokens
+var ErrQueryTooLong = errors.New("query exceeds token limit")
+
+// PrepareQuery applies the query prefix and enforces MaxQueryTokens, so long queries
+// aren't silently cut off by the model. Over-long queries are truncated at a word
+// boundary with a warning, or rejected with ErrQueryTooLong in strict mode.
+func (em *EmbeddingModel) PrepareQuery(text string) (string, error) {
+	query := em.config.QueryPrefix + text
+	limit := em.config.MaxQueryTokens
+	tokens := CountTokens(em.Tokenizer, query)
+	if limit <= 0 || tokens <= limit {
+		return query, nil
+	}
+
+	if em.config.StrictQuery {
+		return "", fmt.Errorf("%w: %d tokens, limit %d", ErrQueryTooLong, tokens, limit)
+	}
+
+	// Binary search for the most words that still fit
+	words := strings.Fields(text)
+	lo, hi := 0, len(words)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if CountTokens(em.Tokenizer, em.config.QueryPrefix+strings.Join(words[:mid], " ")) <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	truncated := em.config.QueryPrefix + strings.Join(words[:lo], " ")
+	slog.Warn("Query truncated", "token_count", tokens, "truncated_token_count", CountTokens(em.Tokenizer, truncated),
+		"token_limit", limit, "kept_words", lo, "word_count", len(words))
+	return truncated, nil
+}
+
+// EmbedQuery embeds a search query: QueryPrefix is prepended and MaxQueryTokens enforced
+// (see PrepareQuery), and the result is L2-normalized when Normalize is set, to match
+// the stored chunks
+func (em *EmbeddingModel) EmbedQuery(text string) ([]float32, error) {
+	query, err := em.PrepareQuery(text)
+	if err != nil {
+		return nil, err
+	}
+
+	em.mu.Lock()
+	embeddings, err := em.embedBatch([]string{query})
+	em.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("error embedding query: %w", err)
+	}
+	if em.config.Normalize {
+		return NormalizeL2(embeddings[0]), nil
+	}
+	return embeddings[0], nil
+}
+
+// embedTexts embeds texts, taking any it can from the in-process cache (when
+// MemoryCacheSize is set) and sending the rest to embedTextsUncached
+func (em *EmbeddingModel) embedTexts(texts []string, tokenLengths []int) ([][]float32, error) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	if em.cache == nil {
+		return em.embedTextsUncached(texts, tokenLengths)
+	}
+	if len(tokenLengths) != len(texts) {
+		return nil, fmt.Errorf("tokenCount length does not match text length")
+	}
+
+	embeddings := make([][]float32, len(texts))
+	hashes := make([]string, len(texts))
+	var missTexts []string
+	var missLengths []int
+	var missPositions []int
+	for i, t := range texts {
+		hashes[i] = TextHash(t)
+		if emb, ok := em.cache.Get(hashes[i]); ok {
+			embeddings[i] = emb
+			continue
+		}
+		missTexts = append(missTexts, t)
+		missLengths = append(missLengths, tokenLengths[i])
+		missPositions = append(missPositions, i)
+	}
+
+	missEmbeddings, err := em.embedTextsUncached(missTexts, missLengths)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missPositions {
+		embeddings[i] = missEmbeddings[j]
+		em.cache.Add(hashes[i], missEmbeddings[j])
+	}
+	return embeddings, nil
+}
+
+// CacheHitRate returns the in-process embedding cache's hit rate and lookup count, both
+// 0 if the cache is disabled
+func (em *EmbeddingModel) CacheHitRate() (rate float64, lookups int64) {
+	hits, misses := em.cache.Stats()
+	return em.cache.HitRate(), hits + misses
+}
+
+// embedTextsUncached embeds texts in batches. With DedupTexts, each distinct text is
+// embedded once and its embedding copied to every position it appears at.
+func (em *EmbeddingModel) embedTextsUncached(texts []string, tokenLengths []int) ([][]float32, error) {
+	if !em.config.DedupTexts {
+		return em.embedBatches(texts, tokenLengths)
+	}
+	if len(tokenLengths) != len(texts) {
+		return nil, fmt.Errorf("tokenCount length does not match text length")
+	}
+
+	uniqueIndex := make(map[string]int, len(texts))
+	positions := make([]int, len(texts))
+	var uniqueTexts []string
+	var uniqueLengths []int
+	for i, t := range texts {
+		idx, ok := uniqueIndex[t]
+		if !ok {
+			idx = len(uniqueTexts)
+			uniqueIndex[t] = idx
+			uniqueTexts = append(uniqueTexts, t)
+			uniqueLengths = append(uniqueLengths, tokenLengths[i])
+		}
+		positions[i] = idx
+	}
+
+	uniqueEmbeddings, err := em.embedBatches(uniqueTexts, uniqueLengths)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy so callers that modify one embedding in place don't affect its duplicates
+	embeddings := make([][]float32, len(texts))
+	for i, idx := range positions {
+		embeddings[i] = append([]float32(nil), uniqueEmbeddings[idx]...)
+	}
+	return embeddings, nil
+}
+
+// embedBatches processes texts in multiple batches. Texts are batched in order of token
+// length so each batch pads to a similar length; embeddings come back in input order.
+// A batch ends when the padded token budget or MaxBatchSize (if set) would be exceeded.
 func (em *EmbeddingModel) embedBatches(texts []string, tokenLengths []int) ([][]float32, error) {
 	if len(texts) == 0 {
 		return [][]float32{}, nil
@@ -151,10 +531,27 @@ func (em *EmbeddingModel) embedBatches(texts []string, tokenLengths []int) ([][]
 		return nil, fmt.Errorf("tokenCount length does not match text length")
 	}
 
+	// Bucket by length: order[k] is the input index of the k-th shortest text
+	order := make([]int, len(texts))
+	for k := range order {
+		order[k] = k
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return tokenLengths[order[a]] < tokenLengths[order[b]]
+	})
+	sortedTexts := make([]string, len(texts))
+	sortedLengths := make([]int, len(texts))
+	for k, idx := range order {
+		sortedTexts[k] = texts[idx]
+		sortedLengths[k] = tokenLengths[idx]
+	}
+	texts, tokenLengths = sortedTexts, sortedLengths
+
 	allEmbeddings := make([][]float32, 0, len(texts))
 
 	i := 0
 	for i < len(texts) {
+		start := i
 		batchTexts := []string{}
 		maxSeqLen := 0
 
@@ -168,7 +565,10 @@ func (em *EmbeddingModel) embedBatches(texts []string, tokenLengths []int) ([][]
 			totalTokens := (len(batchTexts) + 1) * newMaxSeqLen
 
 			// Check if adding this text would exceed budget
-			if len(batchTexts) > 0 && totalTokens > em.config.MaxBatchTokens {
+			if len(batchTexts) > 0 && totalTokens > em.batchTokenLimit {
+				break
+			}
+			if em.config.MaxBatchSize > 0 && len(batchTexts) >= em.config.MaxBatchSize {
 				break
 			}
 
@@ -178,7 +578,7 @@ func (em *EmbeddingModel) embedBatches(texts []string, tokenLengths []int) ([][]
 		}
 
 		// Process batch
-		embeddings, err := em.embedBatch(batchTexts)
+		embeddings, err := em.embedBatchSplitting(batchTexts, tokenLengths[start:i])
 		if err != nil {
 			return nil, fmt.Errorf("batch failed: %w", err)
 		}
@@ -186,11 +586,57 @@ func (em *EmbeddingModel) embedBatches(texts []string, tokenLengths []int) ([][]
 		allEmbeddings = append(allEmbeddings, embeddings...)
 	}
 
-	return allEmbeddings, nil
+	// Restore input order
+	result := make([][]float32, len(allEmbeddings))
+	for k, idx := range order {
+		result[idx] = allEmbeddings[k]
+	}
+	return result, nil
+}
+
+// embedBatchSplitting embeds one packed batch, halving it on OOM and retrying each half
+// (down to a single text). The token cost of the halves becomes the new batch budget,
+// so later batches are packed small enough to fit.
+func (em *EmbeddingModel) embedBatchSplitting(texts []string, tokenLengths []int) ([][]float32, error) {
+	embeddings, err := em.embedBatch(texts)
+	if err == nil || len(texts) == 1 || !isOOMError(err) {
+		return embeddings, err
+	}
+
+	mid := len(texts) / 2
+	halfTokens := max(batchTokens(tokenLengths[:mid]), batchTokens(tokenLengths[mid:]))
+	if halfTokens < em.batchTokenLimit {
+		em.batchTokenLimit = halfTokens
+	}
+	slog.Warn("Batch ran out of memory, splitting",
+		"batch_size", len(texts), "batch_token_limit", em.batchTokenLimit, "error", err)
+
+	first, err := em.embedBatchSplitting(texts[:mid], tokenLengths[:mid])
+	if err != nil {
+		return nil, err
+	}
+	second, err := em.embedBatchSplitting(texts[mid:], tokenLengths[mid:])
+	if err != nil {
+		return nil, err
+	}
+	return append(first, second...), nil
+}
+
+// batchTokens is the padded token cost of a batch, as budgeted by embedBatches
+func batchTokens(tokenLengths []int) int {
+	maxLen := 0
+	for _, l := range tokenLengths {
+		maxLen = max(maxLen, l)
+	}
+	return len(tokenLengths) * maxLen
 }
 
 // embedBatch processes a single batch of texts
 func (em *EmbeddingModel) embedBatch(texts []string) ([][]float32, error) {
+	if em.embedFn != nil {
+		return em.embedFn(texts)
+	}
+
 	// Tokenize all texts
 	inputs := make([]tokenizer.EncodeInput, len(texts))
 	for i, t := range texts {
@@ -232,73 +678,225 @@ func (em *EmbeddingModel) embedBatch(texts []string) ([][]float32, error) {
 		}
 	}
 
-	// Create input tensors
-	inputIdsTensor, err := ort.NewTensor(ort.NewShape(int64(batchSize), int64(maxLen)), inputIds)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create input_ids tensor: %w", err)
+	// Create a tensor for each input the model takes, in session order
+	shape := ort.NewShape(int64(batchSize), int64(maxLen))
+	inputData := map[string][]int64{
+		"input_ids":      inputIds,
+		"attention_mask": attentionMask,
+		"token_type_ids": tokenTypeIds,
 	}
-	defer inputIdsTensor.Destroy()
-
-	attentionMaskTensor, err := ort.NewTensor(ort.NewShape(int64(batchSize), int64(maxLen)), attentionMask)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create attention_mask tensor: %w", err)
-	}
-	defer attentionMaskTensor.Destroy()
-
-	tokenTypeIdsTensor, err := ort.NewTensor(ort.NewShape(int64(batchSize), int64(maxLen)), tokenTypeIds)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create token_type_ids tensor: %w", err)
+	inputTensors := make([]ort.Value, len(em.inputNames))
+	for i, name := range em.inputNames {
+		tensor, err := ort.NewTensor(shape, inputData[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s tensor: %w", name, err)
+		}
+		defer tensor.Destroy()
+		inputTensors[i] = tensor
 	}
-	defer tokenTypeIdsTensor.Destroy()
 
 	// Run inference
 
 	// Pre-allocate output tensor with known shape
 	outputs := make([]ort.Value, 1)
 
-	err = em.session.Run(
-		[]ort.Value{inputIdsTensor, attentionMaskTensor, tokenTypeIdsTensor},
-		outputs,
-	)
+	err = em.run(inputTensors, outputs, batchSize)
 	if err != nil {
 		return nil, fmt.Errorf("inference failed: %w", err)
 	}
 	defer outputs[0].Destroy()
 
-	// Type assert to concrete tensor type to access GetData()
-	outputTensor, ok := outputs[0].(*ort.Tensor[float32])
-	if !ok {
-		return nil, fmt.Errorf("output tensor is not float32 type")
+	// Get output data as float32 regardless of the model's output precision
+	outputData, outputShape, err := outputToFloat32(outputs[0])
+	if err != nil {
+		return nil, err
 	}
 
-	outputShape := outputTensor.GetShape()
+	// Output already pooled by the model: [batch_size, hidden_dim]
+	if len(outputShape) == 2 {
+		batchSizeOut, hiddenDim := outputShape[0], outputShape[1]
+		embeddings := make([][]float32, batchSizeOut)
+		for i := int64(0); i < batchSizeOut; i++ {
+			embeddings[i] = make([]float32, hiddenDim)
+			copy(embeddings[i], outputData[i*hiddenDim:(i+1)*hiddenDim])
+		}
+		return embeddings, nil
+	}
+	if len(outputShape) != 3 {
+		return nil, fmt.Errorf("unexpected output shape %v, expected [batch, seq, hidden] or [batch, hidden]", outputShape)
+	}
 
 	// Output: [batch_size, sequence_length, hidden_dim]
 	batchSizeOut := outputShape[0]
 	seqLen := outputShape[1]
 	hiddenDim := outputShape[2]
 
-	// Get raw float32 data
-	outputData := outputTensor.GetData()
+	poolIndex := int64(em.config.PoolingTokenIndex)
+	if poolIndex < 0 || poolIndex >= seqLen {
+		return nil, fmt.Errorf("pooling token index %d out of range for sequence length %d", poolIndex, seqLen)
+	}
 
-	// Extract [CLS] token embedding (first token of each sequence)
+	// Extract the pooling token's embedding ([CLS], the first token, by default)
 	// IMPORTANT: Copy the data before the output tensor is destroyed
 	embeddings := make([][]float32, batchSizeOut)
 	for i := int64(0); i < batchSizeOut; i++ {
-		clsStart := i * seqLen * hiddenDim
-		clsEnd := clsStart + hiddenDim
+		tokenStart := (i*seqLen + poolIndex) * hiddenDim
+		tokenEnd := tokenStart + hiddenDim
 		// Make a copy so we don't reference the tensor's memory after it's destroyed
 		embeddings[i] = make([]float32, hiddenDim)
-		copy(embeddings[i], outputData[clsStart:clsEnd])
+		copy(embeddings[i], outputData[tokenStart:tokenEnd])
 	}
 	return embeddings, nil
 }
 
-// Close releases resources
+// outputToFloat32 reads a float32, float64, float16 or bfloat16 output tensor as float32
+// data. Other output types are rejected; export such models with a float32 output.
+func outputToFloat32(value ort.Value) ([]float32, ort.Shape, error) {
+	switch t := value.(type) {
+	case *ort.Tensor[float32]:
+		return t.GetData(), t.GetShape(), nil
+
+	case *ort.Tensor[float64]:
+		return float64sToFloat32(t.GetData()), t.GetShape(), nil
+
+	case *ort.CustomDataTensor:
+		// onnxruntime_go returns half-precision outputs as raw bytes
+		var convert func(uint16) float32
+		switch ort.TensorElementDataType(t.DataType()) {
+		case ort.TensorElementDataTypeFloat16:
+			convert = float16ToFloat32
+		case ort.TensorElementDataTypeBFloat16:
+			convert = bfloat16ToFloat32
+		default:
+			return nil, nil, fmt.Errorf("unsupported output tensor (custom data type %d, shape %v); "+
+				"export the model with a float32 output", t.DataType(), t.GetShape())
+		}
+		data, err := halfsToFloat32(t.GetData(), convert)
+		if err != nil {
+			return nil, nil, err
+		}
+		return data, t.GetShape(), nil
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported output tensor type %T", value)
+	}
+}
+
+// float64sToFloat32 converts float64 output data to float32
+func float64sToFloat32(data []float64) []float32 {
+	converted := make([]float32, len(data))
+	for i, v := range data {
+		converted[i] = float32(v)
+	}
+	return converted
+}
+
+// halfsToFloat32 converts raw 16-bit output data (little-endian, as ONNX Runtime stores
+// it on the platforms it runs on) to float32 using convert
+func halfsToFloat32(data []byte, convert func(uint16) float32) ([]float32, error) {
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("16-bit output data has odd length %d", len(data))
+	}
+	converted := make([]float32, len(data)/2)
+	for i := range converted {
+		converted[i] = convert(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+	return converted, nil
+}
+
+// float16ToFloat32 converts an IEEE 754 half-precision value to float32, exactly
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+
+	switch exp {
+	case 0x1f:
+		// Inf or NaN, keeping the NaN payload
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	case 0:
+		// Zero or subnormal: frac * 2^-24
+		v := float32(frac) / (1 << 24)
+		if sign != 0 {
+			v = -v
+		}
+		return v
+	default:
+		// Rebias the exponent from 15 to 127
+		return math.Float32frombits(sign | (exp+127-15)<<23 | frac<<13)
+	}
+}
+
+// bfloat16ToFloat32 converts a bfloat16 value, the top half of a float32, to float32
+func bfloat16ToFloat32(b uint16) float32 {
+	return math.Float32frombits(uint32(b) << 16)
+}
+
+// EmbeddingStats summarizes a set of embeddings for quality monitoring
+type EmbeddingStats struct {
+	Count                  int
+	MeanNorm               float32
+	MeanPairwiseSimilarity float32 // over all pairs of non-zero vectors
+	NearZeroFraction       float32 // fraction of vectors with norm below nearZeroNorm
+}
+
+const nearZeroNorm = 1e-6
+
+// ComputeEmbeddingStats computes norm and similarity statistics over embeddings.
+// Pairwise similarity is O(n^2), which is fine for the chunks of a single lecture.
+func ComputeEmbeddingStats(embeddings [][]float32) EmbeddingStats {
+	stats := EmbeddingStats{Count: len(embeddings)}
+	if len(embeddings) == 0 {
+		return stats
+	}
+
+	var normSum float64
+	nearZero := 0
+	nonZero := make([][]float32, 0, len(embeddings))
+	for _, emb := range embeddings {
+		var sq float64
+		for _, v := range emb {
+			sq += float64(v) * float64(v)
+		}
+		norm := math.Sqrt(sq)
+		normSum += norm
+
+		if norm < nearZeroNorm {
+			nearZero++
+		} else {
+			nonZero = append(nonZero, emb)
+		}
+	}
+	stats.MeanNorm = float32(normSum / float64(len(embeddings)))
+	stats.NearZeroFraction = float32(nearZero) / float32(len(embeddings))
+
+	var simSum float64
+	pairs := 0
+	for i := 0; i < len(nonZero); i++ {
+		for j := i + 1; j < len(nonZero); j++ {
+			sim, err := CosineSimilarity(nonZero[i], nonZero[j])
+			if err != nil {
+				continue
+			}
+			simSum += float64(sim)
+			pairs++
+		}
+	}
+	if pairs > 0 {
+		stats.MeanPairwiseSimilarity = float32(simSum / float64(pairs))
+	}
+
+	return stats
+}
+
+// Close releases resources. The model runs in-process through ONNX Runtime, so there is
+// no child process to signal or wait on; Close is safe to call more than once.
 func (em *EmbeddingModel) Close() error {
-	if em.session != nil {
-		em.session.Destroy()
+	if em.session == nil {
+		return nil
 	}
+	em.session.Destroy()
+	em.session = nil
 	ort.DestroyEnvironment()
 	return nil
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// MemoryEmbeddingCache is an in-process LRU cache of embeddings keyed by TextHash, shared
+// across lectures so recurring text skips tokenization and the model
+type MemoryEmbeddingCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List               // front = most recently used
+	entries  map[string]*list.Element // hash -> element holding a memoryCacheEntry
+
+	hits   int64
+	misses int64
+}
+
+type memoryCacheEntry struct {
+	hash      string
+	embedding []float32
+}
+
+// NewMemoryEmbeddingCache returns an LRU cache holding up to capacity embeddings, or nil
+// if capacity is not positive
+func NewMemoryEmbeddingCache(capacity int) *MemoryEmbeddingCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &MemoryEmbeddingCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element, capacity),
+	}
+}
+
+// Get returns a copy of the cached embedding for hash and counts the hit or miss
+func (c *MemoryEmbeddingCache) Get(hash string) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[hash]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(elem)
+	return append([]float32(nil), elem.Value.(*memoryCacheEntry).embedding...), true
+}
+
+// Add stores a copy of embedding under hash, evicting the least recently used entry when full
+func (c *MemoryEmbeddingCache) Add(hash string, embedding []float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	embedding = append([]float32(nil), embedding...)
+	if elem, ok := c.entries[hash]; ok {
+		elem.Value.(*memoryCacheEntry).embedding = embedding
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[hash] = c.order.PushFront(&memoryCacheEntry{hash: hash, embedding: embedding})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).hash)
+	}
+}
+
+// Stats returns the hit and miss counts since the cache was created
+func (c *MemoryEmbeddingCache) Stats() (hits, misses int64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// HitRate returns the fraction of lookups that were hits, 0 before any lookup
+func (c *MemoryEmbeddingCache) HitRate() float64 {
+	hits, misses := c.Stats()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sugarme/tokenizer/pretrained"
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// mapEmbeddingCache is an in-memory EmbeddingCache
+type mapEmbeddingCache map[string][]float32
+
+func (c mapEmbeddingCache) Lookup(_ context.Context, hashes []string) (map[string][]float32, error) {
+	found := make(map[string][]float32)
+	for _, h := range hashes {
+		if emb, ok := c[h]; ok {
+			found[h] = emb
+		}
+	}
+	return found, nil
+}
+
+func (c mapEmbeddingCache) Store(_ context.Context, entries map[string][]float32) error {
+	for h, emb := range entries {
+		c[h] = emb
+	}
+	return nil
+}
+
+// newFakeEmbeddingModel returns a model whose batches are embedded by length and
+// recorded in *embedded instead of running an ONNX session
+func newFakeEmbeddingModel(config EmbeddingConfig, embedded *[]string) *EmbeddingModel {
+	return &EmbeddingModel{
+		config:          config,
+		batchTokenLimit: 1 << 20,
+		embedFn: func(texts []string) ([][]float32, error) {
+			*embedded = append(*embedded, texts...)
+			embeddings := make([][]float32, len(texts))
+			for i, t := range texts {
+				embeddings[i] = []float32{float32(len(t)), 1}
+			}
+			return embeddings, nil
+		},
+	}
+}
+
+func sentencesFrom(texts ...string) []*Sentence {
+	sentences := make([]*Sentence, len(texts))
+	for i, t := range texts {
+		sentences[i] = &Sentence{Text: t, TokenCount: 4}
+	}
+	return sentences
+}
+
+func TestEmbedSentencesCachedOnlyEmbedsChangedSentences(t *testing.T) {
+	ctx := context.Background()
+	cache := mapEmbeddingCache{}
+	var embedded []string
+	em := newFakeEmbeddingModel(EmbeddingConfig{ModelName: "model-a"}, &embedded)
+
+	first := sentencesFrom("Welcome back.", "Today is paging.", "Any questions?", "See you Monday.")
+	if hits, err := em.EmbedSentencesCached(ctx, first, cache); err != nil || hits != 0 {
+		t.Fatalf("first run: hits=%d err=%v, want 0 hits", hits, err)
+	}
+
+	embedded = nil
+	second := sentencesFrom("Welcome back.", "Today is segmentation.", "Any questions?", "See you Monday.")
+	hits, err := em.EmbedSentencesCached(ctx, second, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits != 3 {
+		t.Errorf("hits = %d, want 3", hits)
+	}
+	if want := []string{"Today is segmentation."}; !reflect.DeepEqual(embedded, want) {
+		t.Errorf("embedded %q, want only %q", embedded, want)
+	}
+	for i, s := range second {
+		if len(s.Embedding) == 0 {
+			t.Errorf("sentence %d has no embedding", i)
+		}
+	}
+}
+
+func TestEmbedSentencesCachedKeyedByModelAndPrefix(t *testing.T) {
+	ctx := context.Background()
+	cache := mapEmbeddingCache{}
+	var embedded []string
+
+	em := newFakeEmbeddingModel(EmbeddingConfig{ModelName: "model-a"}, &embedded)
+	if _, err := em.EmbedSentencesCached(ctx, sentencesFrom("Same text."), cache); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, config := range []EmbeddingConfig{
+		{ModelName: "model-b"},
+		{ModelName: "model-a", PassagePrefix: "passage: "},
+	} {
+		embedded = nil
+		em := newFakeEmbeddingModel(config, &embedded)
+		hits, err := em.EmbedSentencesCached(ctx, sentencesFrom("Same text."), cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hits != 0 || len(embedded) != 1 {
+			t.Errorf("%+v: hits=%d embedded=%q, want a miss", config, hits, embedded)
+		}
+	}
+}
+
+// initTestONNXRuntime initializes ONNX Runtime from ONNXRUNTIME_LIB_PATH, which tensors
+// need even when no model is loaded, skipping the test if the library isn't available
+func initTestONNXRuntime(t *testing.T) {
+	t.Helper()
+	if ort.IsInitialized() {
+		return
+	}
+	path := os.Getenv("ONNXRUNTIME_LIB_PATH")
+	if path == "" {
+		t.Skip("ONNXRUNTIME_LIB_PATH not set")
+	}
+	ort.SetSharedLibraryPath(path)
+	if err := ort.InitializeEnvironment(); err != nil {
+		t.Skipf("ONNX Runtime not available: %v", err)
+	}
+}
+
+func TestEmbedBatchConvertsFloat64Output(t *testing.T) {
+	initTestONNXRuntime(t)
+	tok, err := pretrained.FromFile("tokenizer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A model with a pooled float64 output: [batch, 2]
+	want := [][]float32{{0.1, -2.5}, {1e-3, 4}}
+	em := &EmbeddingModel{
+		Tokenizer:  tok,
+		inputNames: []string{"input_ids", "attention_mask"},
+		runFn: func(inputs, outputs []ort.Value) error {
+			if batch := inputs[0].GetShape()[0]; batch != 2 {
+				t.Errorf("input batch size = %d, want 2", batch)
+			}
+			output, err := ort.NewTensor(ort.NewShape(2, 2), []float64{0.1, -2.5, 1e-3, 4})
+			outputs[0] = output
+			return err
+		},
+	}
+
+	got, err := em.embedBatch([]string{"first text", "second text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("embedBatch = %v, want %v", got, want)
+	}
+}
+
+func TestOutputToFloat32HalfPrecision(t *testing.T) {
+	initTestONNXRuntime(t)
+
+	// 1.5, -2 and 0.000061035156 (the smallest normal) as float16
+	f16, err := ort.NewCustomDataTensor(ort.NewShape(1, 3), []byte{0x00, 0x3e, 0x00, 0xc0, 0x00, 0x04},
+		ort.TensorElementDataTypeFloat16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f16.Destroy()
+	got, shape, err := outputToFloat32(f16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float32{1.5, -2, 1.0 / (1 << 14)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("float16 output = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(shape, ort.NewShape(1, 3)) {
+		t.Errorf("shape = %v, want [1 3]", shape)
+	}
+
+	// 1.5 and -2 as bfloat16
+	bf16, err := ort.NewCustomDataTensor(ort.NewShape(1, 2), []byte{0xc0, 0x3f, 0x00, 0xc0},
+		ort.TensorElementDataTypeBFloat16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bf16.Destroy()
+	if got, _, err := outputToFloat32(bf16); err != nil || !reflect.DeepEqual(got, []float32{1.5, -2}) {
+		t.Errorf("bfloat16 output = %v, %v, want [1.5 -2]", got, err)
+	}
+}
+
+func TestFloat16ToFloat32(t *testing.T) {
+	tests := []struct {
+		bits uint16
+		want float32
+	}{
+		{0x0000, 0},
+		{0x3c00, 1},
+		{0xc000, -2},
+		{0x3555, 1365.0 / 4096},
+		{0x7bff, 65504},                 // largest normal
+		{0x0001, 1.0 / (1 << 24)},       // smallest subnormal
+		{0x83ff, -1023.0 / (1 << 24)},   // largest negative subnormal
+		{0x7c00, float32(math.Inf(1))},  // +Inf
+		{0xfc00, float32(math.Inf(-1))}, // -Inf
+	}
+
+	for _, tt := range tests {
+		if got := float16ToFloat32(tt.bits); got != tt.want {
+			t.Errorf("float16ToFloat32(%#04x) = %v, want %v", tt.bits, got, tt.want)
+		}
+	}
+	if got := float16ToFloat32(0x7e00); !math.IsNaN(float64(got)) {
+		t.Errorf("float16ToFloat32(0x7e00) = %v, want NaN", got)
+	}
+	if got := float16ToFloat32(0x8000); got != 0 || !math.Signbit(float64(got)) {
+		t.Errorf("float16ToFloat32(0x8000) = %v, want -0", got)
+	}
+}
+
+func TestBFloat16ToFloat32(t *testing.T) {
+	tests := []struct {
+		bits uint16
+		want float32
+	}{
+		{0x0000, 0},
+		{0x3f80, 1},
+		{0xc000, -2},
+		{0x3fc0, 1.5},
+		{0x7f80, float32(math.Inf(1))},
+	}
+
+	for _, tt := range tests {
+		if got := bfloat16ToFloat32(tt.bits); got != tt.want {
+			t.Errorf("bfloat16ToFloat32(%#04x) = %v, want %v", tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestHalfsToFloat32RejectsOddLength(t *testing.T) {
+	if _, err := halfsToFloat32([]byte{0x00, 0x3c, 0x00}, float16ToFloat32); err == nil {
+		t.Error("halfsToFloat32 accepted 3 bytes")
+	}
+}
+
+func TestComputeEmbeddingStats(t *testing.T) {
+	// Norms 1, 2, 0 and 1; similarities between the non-zero vectors are 0, -1 and 0
+	embeddings := [][]float32{{1, 0}, {0, 2}, {0, 0}, {-1, 0}}
+	stats := ComputeEmbeddingStats(embeddings)
+
+	const eps = 1e-6
+	if stats.Count != 4 {
+		t.Errorf("Count = %d, want 4", stats.Count)
+	}
+	if math.Abs(float64(stats.MeanNorm)-1) > eps {
+		t.Errorf("MeanNorm = %v, want 1", stats.MeanNorm)
+	}
+	if math.Abs(float64(stats.NearZeroFraction)-0.25) > eps {
+		t.Errorf("NearZeroFraction = %v, want 0.25", stats.NearZeroFraction)
+	}
+	if math.Abs(float64(stats.MeanPairwiseSimilarity)+1.0/3) > eps {
+		t.Errorf("MeanPairwiseSimilarity = %v, want -1/3", stats.MeanPairwiseSimilarity)
+	}
+}
+
+func TestComputeEmbeddingStatsEmpty(t *testing.T) {
+	if stats := ComputeEmbeddingStats(nil); stats != (EmbeddingStats{}) {
+		t.Errorf("ComputeEmbeddingStats(nil) = %+v, want zero stats", stats)
+	}
+}
+
+func TestPrefixesPrependedBeforeTokenization(t *testing.T) {
+	tok, err := pretrained.FromFile("tokenizer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := EmbeddingConfig{QueryPrefix: "query: ", PassagePrefix: "passage: "}
+	var embedded []string
+	em := newFakeEmbeddingModel(config, &embedded)
+	em.Tokenizer = tok
+
+	chunks := []*Chunk{{Text: "Paging maps virtual pages to frames."}}
+	if err := em.EmbedChunks(chunks); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"passage: Paging maps virtual pages to frames."}; !reflect.DeepEqual(embedded, want) {
+		t.Errorf("EmbedChunks embedded %q, want %q", embedded, want)
+	}
+	if chunks[0].Text != "Paging maps virtual pages to frames." {
+		t.Errorf("chunk text changed to %q, want it stored un-prefixed", chunks[0].Text)
+	}
+
+	embedded = nil
+	if _, err := em.EmbedQuery("what is a page table"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"query: what is a page table"}; !reflect.DeepEqual(embedded, want) {
+		t.Errorf("EmbedQuery embedded %q, want %q", embedded, want)
+	}
+}
+
+func TestCloseIsIdempotent(t *testing.T) {
+	var embedded []string
+	em := newFakeEmbeddingModel(EmbeddingConfig{}, &embedded)
+	if err := em.EmbedSentences(sentencesFrom("Welcome back.")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two concurrent calls, then a third after both have returned
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- em.Close() }()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("concurrent Close: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close did not return")
+		}
+	}
+	if err := em.Close(); err != nil {
+		t.Errorf("Close after Close: %v", err)
+	}
+}
@@ -20,3 +20,7 @@ require (
 	golang.org/x/text v0.25.0 // indirect
 	gopkg.in/inf.v0 v0.9.1 // indirect
 )
+
+require piazza-bot/shared v0.0.0
+
+replace piazza-bot/shared => ../shared
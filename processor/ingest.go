@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// IngestOptions configures an offline run of the pipeline on a local transcript file
+type IngestOptions struct {
+	File       string // path to an SRT (or plain text) transcript, "" = normal Kafka mode
+	Event      TranscriptEvent
+	Insert     bool // also write the chunks to Cassandra
+	Embeddings bool // include embedding vectors in the JSON output
+}
+
+// registerIngestFlags defines the offline ingest flags, filled in by flag.Parse:
+//
+//	processor -file lecture.srt -class CS544 -prof Smith -sem FA25 -url https://... [-insert]
+func registerIngestFlags() *IngestOptions {
+	var opts IngestOptions
+	flag.StringVar(&opts.File, "file", "", "process a local transcript file instead of consuming from Kafka")
+	flag.StringVar(&opts.Event.ClassName, "class", "", "class name of the transcript (with -file or -inspect)")
+	flag.StringVar(&opts.Event.Professor, "prof", "", "professor of the transcript (with -file or -inspect)")
+	flag.StringVar(&opts.Event.Semester, "sem", "", "semester of the transcript (with -file or -inspect)")
+	flag.StringVar(&opts.Event.URL, "url", "", "lecture URL of the transcript (with -file or -inspect)")
+	flag.StringVar(&opts.Event.LectureTitle, "title", "", "lecture title (with -file)")
+	flag.IntVar(&opts.Event.LectureNumber, "lecture", 0, "lecture number (with -file)")
+	flag.BoolVar(&opts.Insert, "insert", false, "also insert the chunks into Cassandra (with -file)")
+	flag.BoolVar(&opts.Embeddings, "embeddings", false, "include embedding vectors in the JSON output (with -file)")
+	return &opts
+}
+
+// ingestChunk is the JSON form of one chunk printed by runIngest
+type ingestChunk struct {
+	ChunkIndex        int       `json:"chunk_index"`
+	ChunkText         string    `json:"chunk_text"`
+	TokenCount        int       `json:"token_count"`
+	LectureTimestamp  string    `json:"lecture_timestamp"`
+	LectureStartMs    int64     `json:"lecture_start_ms"`
+	LectureEndTime    string    `json:"lecture_end_time"`
+	ContinuesPrevious bool      `json:"continues_previous"`
+	Keywords          []string  `json:"keywords,omitempty"`
+	Embedding         []float32 `json:"embedding,omitempty"`
+}
+
+// runIngest runs the pipeline on opts.File without Kafka, printing the resulting chunks
+// as JSON to stdout. Cassandra is only used with opts.Insert; the sentence cache and
+// completion events are skipped.
+func runIngest(opts IngestOptions) error {
+	event := &opts.Event
+	if err := event.validate(); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(opts.File)
+	if err != nil {
+		return fmt.Errorf("failed to read transcript file: %w", err)
+	}
+
+	embeddingConfig := LoadEmbeddingConfig()
+	processorConfig := LoadProcessorConfig()
+
+	slog.Info("Loading embedding model")
+	embeddingModel, err := InitEmbeddingModel(embeddingConfig)
+	if err != nil {
+		return fmt.Errorf("failed to load embedding model: %w", err)
+	}
+	defer embeddingModel.Close()
+
+	chunks, untimed, err := embedTranscript(context.Background(), nil, embeddingModel, processorConfig, event, string(data))
+	if err != nil {
+		return err
+	}
+	rows := buildEmbeddingsRows(event, chunks, untimed, embeddingModel, processorConfig)
+
+	if opts.Insert {
+		cassandraConfig := LoadCassandraConfig()
+		slog.Info("Connecting to Cassandra", "hosts", cassandraConfig.CassandraHosts)
+		session, err := ConnectCassandra(cassandraConfig)
+		if err != nil {
+			return fmt.Errorf("failed to connect to Cassandra: %w", err)
+		}
+		store := NewCassandraStore(session, cassandraConfig)
+		defer store.Session().Close()
+
+		ctx := context.Background()
+		if err := storeEmbeddingsRows(ctx, store, processorConfig, event, rows); err != nil {
+			return err
+		}
+		if err := storeSentenceEmbeddings(ctx, store, processorConfig, event, chunks, embeddingModel); err != nil {
+			return err
+		}
+	}
+
+	output := make([]ingestChunk, len(rows))
+	for i, row := range rows {
+		output[i] = ingestChunk{
+			ChunkIndex:        row.ChunkIndex,
+			ChunkText:         row.ChunkText,
+			TokenCount:        row.TokenCount,
+			LectureTimestamp:  row.LectureTimestamp,
+			LectureStartMs:    row.LectureStartMs,
+			LectureEndTime:    row.LectureEndTime,
+			ContinuesPrevious: row.ContinuesPrevious,
+			Keywords:          row.Keywords,
+		}
+		if opts.Embeddings {
+			output[i].Embedding = row.Embedding
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		return fmt.Errorf("failed to write chunks: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// InspectOptions configures printing the chunks stored for one lecture
+type InspectOptions struct {
+	Enabled         bool
+	EmbeddingValues int // leading embedding values printed per chunk, 0 = none
+}
+
+// registerInspectFlags defines the inspect flags, filled in by flag.Parse. The lecture is
+// picked with the same -class, -prof, -sem and -url flags as -file:
+//
+//	processor -inspect -class CS544 -prof Smith -sem FA25 -url https://... [-show-embedding 8]
+func registerInspectFlags() *InspectOptions {
+	var opts InspectOptions
+	flag.BoolVar(&opts.Enabled, "inspect", false, "print the chunks stored in Cassandra for one lecture")
+	flag.IntVar(&opts.EmbeddingValues, "show-embedding", 0, "print the first N embedding values of each chunk (with -inspect)")
+	return &opts
+}
+
+// runInspect prints the index, token count, timestamp and text of every chunk stored for
+// the lecture identified by key, for debugging retrieval quality
+func runInspect(opts InspectOptions, key TranscriptEvent) error {
+	if key.ClassName == "" || key.Professor == "" || key.Semester == "" || key.URL == "" {
+		return fmt.Errorf("-inspect needs -class, -prof, -sem and -url")
+	}
+
+	cassandraConfig := LoadCassandraConfig()
+	slog.Info("Connecting to Cassandra", "hosts", cassandraConfig.CassandraHosts)
+	session, err := ConnectCassandra(cassandraConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Cassandra: %w", err)
+	}
+	defer session.Close()
+
+	rows, err := FetchEmbeddingsForURL(context.Background(), session, key.ClassName, key.Professor, key.Semester, key.URL)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		fmt.Printf("No chunks stored for %s/%s/%s url=%s\n", key.ClassName, key.Professor, key.Semester, key.URL)
+		return nil
+	}
+
+	first := rows[0]
+	fmt.Printf("%s (%d chunks, model %s, dim %d)\n\n", first.LectureTitle, len(rows), first.ModelName, first.EmbeddingDim)
+	for _, row := range rows {
+		timestamp := row.LectureTimestamp
+		if row.Untimed {
+			timestamp = "untimed"
+		} else if row.LectureEndTime != "" {
+			timestamp += " - " + row.LectureEndTime
+		}
+		fmt.Printf("[%d] %d tokens, %s\n", row.ChunkIndex, row.TokenCount, timestamp)
+		if opts.EmbeddingValues > 0 {
+			fmt.Printf("    embedding: %s\n", formatEmbeddingHead(row.Embedding, opts.EmbeddingValues))
+		}
+		fmt.Printf("    %s\n\n", row.ChunkText)
+	}
+	return nil
+}
+
+// formatEmbeddingHead formats the first n values of embedding, e.g. [0.0123 -0.0456 ...]
+func formatEmbeddingHead(embedding []float32, n int) string {
+	values := make([]string, 0, n+1)
+	for _, v := range embedding[:min(n, len(embedding))] {
+		values = append(values, fmt.Sprintf("%.4f", v))
+	}
+	if n < len(embedding) {
+		values = append(values, "...")
+	}
+	return "[" + strings.Join(values, " ") + "]"
+}
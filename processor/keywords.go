@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// stopwords are common English words excluded from chunk keywords
+var stopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true, "you": true,
+	"all": true, "any": true, "can": true, "had": true, "her": true, "was": true, "one": true,
+	"our": true, "out": true, "has": true, "have": true, "him": true, "his": true, "how": true,
+	"its": true, "may": true, "new": true, "now": true, "old": true, "see": true, "two": true,
+	"who": true, "did": true, "get": true, "got": true, "let": true, "say": true, "she": true,
+	"too": true, "use": true, "that": true, "this": true, "with": true, "from": true, "they": true,
+	"will": true, "would": true, "there": true, "their": true, "what": true, "about": true,
+	"which": true, "when": true, "make": true, "like": true, "just": true, "know": true,
+	"take": true, "into": true, "your": true, "some": true, "could": true, "them": true,
+	"than": true, "then": true, "look": true, "only": true, "come": true, "over": true,
+	"think": true, "also": true, "back": true, "after": true, "work": true, "well": true,
+	"way": true, "even": true, "want": true, "because": true, "these": true, "give": true,
+	"most": true, "very": true, "were": true, "been": true, "being": true, "does": true,
+	"doing": true, "here": true, "where": true, "why": true, "going": true, "okay": true,
+	"yeah": true, "right": true, "really": true, "actually": true, "basically": true,
+	"kind": true, "thing": true, "things": true, "gonna": true, "something": true,
+	"should": true, "other": true, "more": true, "each": true, "such": true, "those": true,
+	"said": true, "same": true, "don": true, "didn": true, "doesn": true, "isn": true,
+	"aren": true, "wasn": true, "won": true,
+}
+
+// ExtractKeywords returns the top-N TF-IDF terms for each chunk, treating the lecture's
+// chunks as the document collection. Stopwords and words of 2 or fewer characters are
+// excluded. Ties are broken alphabetically so the output is deterministic.
+func ExtractKeywords(chunkTexts []string, topN int) [][]string {
+	keywords := make([][]string, len(chunkTexts))
+	if topN <= 0 || len(chunkTexts) == 0 {
+		return keywords
+	}
+
+	// Term frequencies per chunk and document frequency across chunks
+	termFreqs := make([]map[string]int, len(chunkTexts))
+	docFreq := make(map[string]int)
+	for i, text := range chunkTexts {
+		tf := make(map[string]int)
+		for _, word := range splitTerms(text) {
+			if len(word) <= 2 || stopwords[word] {
+				continue
+			}
+			tf[word]++
+		}
+		for term := range tf {
+			docFreq[term]++
+		}
+		termFreqs[i] = tf
+	}
+
+	type scoredTerm struct {
+		term  string
+		score float64
+	}
+
+	numDocs := float64(len(chunkTexts))
+	for i, tf := range termFreqs {
+		scored := make([]scoredTerm, 0, len(tf))
+		for term, count := range tf {
+			// Smoothed IDF keeps terms present in every chunk above zero
+			idf := math.Log((1+numDocs)/(1+float64(docFreq[term]))) + 1
+			scored = append(scored, scoredTerm{term, float64(count) * idf})
+		}
+
+		sort.Slice(scored, func(a, b int) bool {
+			if scored[a].score != scored[b].score {
+				return scored[a].score > scored[b].score
+			}
+			return scored[a].term < scored[b].term
+		})
+
+		n := topN
+		if n > len(scored) {
+			n = len(scored)
+		}
+		keywords[i] = make([]string, n)
+		for k := 0; k < n; k++ {
+			keywords[i][k] = scored[k].term
+		}
+	}
+
+	return keywords
+}
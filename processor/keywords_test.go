@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestExtractKeywordsExcludesStopwords(t *testing.T) {
+	chunks := []string{
+		"So the page table maps each virtual page to a physical frame. The page table lives in memory, " +
+			"and that is why we have the TLB, which caches page table entries.",
+		"Now let's talk about the scheduler. The scheduler picks which process runs next, and this " +
+			"scheduler uses round robin with a time slice.",
+		"Okay, so what about locks? A lock protects a critical section, and you should really " +
+			"actually think about the lock before you take it.",
+	}
+
+	keywords := ExtractKeywords(chunks, 3)
+	if len(keywords) != len(chunks) {
+		t.Fatalf("got keywords for %d chunks, want %d", len(keywords), len(chunks))
+	}
+
+	for i, terms := range keywords {
+		if len(terms) != 3 {
+			t.Errorf("chunk %d: got %d keywords %q, want 3", i, len(terms), terms)
+		}
+		for _, term := range terms {
+			if stopwords[term] || len(term) <= 2 {
+				t.Errorf("chunk %d: keyword %q should have been excluded", i, term)
+			}
+		}
+	}
+
+	// The most frequent domain term in each chunk ranks first
+	for i, want := range []string{"page", "scheduler", "lock"} {
+		if len(keywords[i]) == 0 || keywords[i][0] != want {
+			t.Errorf("chunk %d: keywords = %q, want %q first", i, keywords[i], want)
+		}
+	}
+}
+
+func TestExtractKeywordsNoTopN(t *testing.T) {
+	keywords := ExtractKeywords([]string{"page table"}, 0)
+	if len(keywords) != 1 || keywords[0] != nil {
+		t.Errorf("ExtractKeywords(topN=0) = %q, want one empty entry", keywords)
+	}
+}
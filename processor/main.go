@@ -1,15 +1,24 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/rand"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
-	gocql "github.com/apache/cassandra-gocql-driver/v2"
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"piazza-bot/shared/health"
+	"piazza-bot/shared/logging"
 )
 
 // TranscriptEvent represents the Kafka message structure
@@ -20,164 +29,745 @@ type TranscriptEvent struct {
 	URL           string `json:"url"`
 	LectureNumber int    `json:"lecture_number"`
 	LectureTitle  string `json:"lecture_title"`
+	Priority      int    `json:"priority,omitempty"` // higher is processed first, default 0
+}
+
+// validate checks the fields that make up the transcript's primary key, so an incomplete
+// event is rejected up front instead of failing as a lookup with empty partition keys
+func (e *TranscriptEvent) validate() error {
+	for _, field := range []struct{ name, value string }{
+		{"class_name", e.ClassName},
+		{"professor", e.Professor},
+		{"semester", e.Semester},
+		{"url", e.URL},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			return fmt.Errorf("invalid transcript event: missing required field %q", field.name)
+		}
+	}
+	return nil
 }
 
 func main() {
+	ingest := registerIngestFlags()
+	reprocess := registerReprocessFlags()
+	inspect := registerInspectFlags()
+	flag.Parse()
+
+	// Offline mode: run the pipeline on a local file instead of consuming from Kafka.
+	// Logs go to stderr so stdout is just the JSON chunks.
+	if ingest.File != "" {
+		logging.Setup(os.Stderr)
+		if err := runIngest(*ingest); err != nil {
+			logging.Fatal("Ingest failed", err)
+		}
+		return
+	}
+
+	// Debug mode: print the chunks stored for one lecture
+	if inspect.Enabled {
+		logging.Setup(os.Stderr)
+		if err := runInspect(*inspect, ingest.Event); err != nil {
+			logging.Fatal("Inspect failed", err)
+		}
+		return
+	}
+
+	// Admin mode: re-embed every stored transcript instead of consuming from Kafka
+	if reprocess.Enabled {
+		logging.Setup(os.Stdout)
+		if err := runReprocessAll(*reprocess); err != nil {
+			logging.Fatal("Reprocess failed", err)
+		}
+		return
+	}
+
+	logging.Setup(os.Stdout)
+
 	// Load configurations
 	kafkaConfig := LoadKafkaConfig()
 	cassandraConfig := LoadCassandraConfig()
-	embeddingConfig := DefaultEmbeddingConfig()
+	embeddingConfig := LoadEmbeddingConfig()
+	processorConfig := LoadProcessorConfig()
 
 	// Create Kafka consumer
-	fmt.Printf("Connecting to Kafka at %s\n", kafkaConfig.BootstrapServers)
-	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers": kafkaConfig.BootstrapServers,
-		"group.id":          kafkaConfig.GroupID,
-		"auto.offset.reset": "earliest",
-	})
+	consumer, err := newConsumer(kafkaConfig)
 	if err != nil {
-		log.Fatalf("Failed to create Kafka consumer: %v", err)
+		logging.Fatal("Failed to create Kafka consumer", err)
 	}
-	defer consumer.Close()
 
-	// Subscribe to topic
-	fmt.Printf("Subscribing to topic: %s\n", kafkaConfig.Topic)
-	err = consumer.SubscribeTopics([]string{kafkaConfig.Topic}, nil)
+	// Producer for transcript-processed events, nil if disabled
+	publisher, err := NewCompletionPublisher(kafkaConfig)
 	if err != nil {
-		log.Fatalf("Failed to subscribe to topic: %v", err)
+		logging.Fatal("Failed to create completion publisher", err)
+	}
+	if publisher != nil {
+		slog.Info("Publishing completion events", "topic", kafkaConfig.CompletionTopic)
 	}
 
 	// Connect to Cassandra
-	fmt.Printf("Connecting to Cassandra at %v\n", cassandraConfig.CassandraHosts)
+	slog.Info("Connecting to Cassandra", "hosts", cassandraConfig.CassandraHosts)
 	session, err := ConnectCassandra(cassandraConfig)
 	if err != nil {
-		log.Fatalf("Failed to connect to Cassandra: %v", err)
+		logging.Fatal("Failed to connect to Cassandra", err)
 	}
-	defer session.Close()
+	store := NewCassandraStore(session, cassandraConfig)
 
 	// Load embedding model
-	fmt.Println("Loading embedding model")
+	slog.Info("Loading embedding model")
 	embeddingModel, err := InitEmbeddingModel(embeddingConfig)
 	if err != nil {
-		log.Fatalf("Failed to load embedding model: %v", err)
+		logging.Fatal("Failed to load embedding model", err)
+	}
+
+	// Liveness/readiness probes
+	health := health.New(processorConfig.LivenessTimeout, "kafka", "cassandra")
+	if processorConfig.HealthAddr != "" {
+		slog.Info("Serving health probes", "addr", processorConfig.HealthAddr)
+		health.Serve(processorConfig.HealthAddr)
 	}
-	defer embeddingModel.Close()
 
-	// signal handling
-	sigchan := make(chan os.Signal, 1)
+	// signal handling: the first signal lets the current message finish, a second forces exit.
+	// If the message is still running after ShutdownTimeout, its Cassandra queries are cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigchan := make(chan os.Signal, 2)
 	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	stopping := make(chan struct{})
+	go func() {
+		sig := <-sigchan
+		slog.Info("Caught signal, finishing current message before shutting down", "signal", sig)
+		close(stopping)
+		time.AfterFunc(processorConfig.ShutdownTimeout, cancel)
 
-	// Poll for messages
-	run := true
-	for run {
-		select {
-		case sig := <-sigchan:
-			fmt.Printf("\nCaught signal %v: terminating\n", sig)
-			run = false
-		default:
-			ev := consumer.Poll(500)
-			if ev == nil {
-				continue
+		sig = <-sigchan
+		slog.Info("Caught signal again, forcing exit", "signal", sig)
+		os.Exit(1)
+	}()
+
+	// Buffer up to PriorityBufferSize ready messages so higher-priority events go first
+	bufferSize := kafkaConfig.PriorityBufferSize
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	buffer := NewPriorityBuffer()
+
+	// Failed attempts of messages that are being redelivered
+	attempts := make(failedAttempts)
+
+	// Backoff before the next consumer reconnect, reset once a message gets through
+	reconnectBackoff := kafkaConfig.ReconnectBackoff
+
+	// Poll for messages until the first signal. Poll blocks for up to PollTimeout while
+	// idle, so the loop waits on Kafka instead of spinning and notices a signal within
+	// one timeout.
+	for !closed(stopping) {
+		health.Tick()
+
+		if !pollInto(consumer, kafkaConfig, buffer, bufferSize, health) {
+			// Buffered messages were never committed, so the new consumer gets them again
+			buffer = NewPriorityBuffer()
+			if consumer = reconnectConsumer(consumer, kafkaConfig, reconnectBackoff, stopping); consumer == nil {
+				break
+			}
+			reconnectBackoff = min(reconnectBackoff*2, kafkaConfig.ReconnectMaxBackoff)
+			continue
+		}
+
+		// A signal that arrived during the poll shouldn't start another transcript
+		pending := buffer.Pop()
+		if pending == nil || closed(stopping) {
+			continue
+		}
+		reconnectBackoff = kafkaConfig.ReconnectBackoff
+
+		if handleMessage(ctx, consumer, kafkaConfig, store, embeddingModel, processorConfig, publisher, health, attempts, pending) {
+			continue
+		}
+
+		// Redeliver the failed offset before any later offset of its partition is committed.
+		// If the seek fails, a new consumer resumes from the last committed offset instead.
+		if !rewindPartition(consumer, buffer, pending) {
+			buffer = NewPriorityBuffer()
+			if consumer = reconnectConsumer(consumer, kafkaConfig, reconnectBackoff, stopping); consumer == nil {
+				break
 			}
+			continue
+		}
+		select {
+		case <-stopping:
+		case <-time.After(kafkaConfig.RetryBackoff):
+		}
+	}
+
+	shutdown(consumer, publisher, embeddingModel, store, processorConfig.ShutdownTimeout)
+}
+
+// kafkaConsumer is the part of *kafka.Consumer used to read and commit messages
+type kafkaConsumer interface {
+	Poll(timeoutMs int) kafka.Event
+	CommitMessage(msg *kafka.Message) ([]kafka.TopicPartition, error)
+	Seek(partition kafka.TopicPartition, ignoredTimeoutMs int) error
+}
+
+// newConsumer creates a Kafka consumer subscribed to the configured topics
+func newConsumer(kafkaConfig *KafkaConfig) (*kafka.Consumer, error) {
+	slog.Info("Connecting to Kafka", "bootstrap_servers", kafkaConfig.BootstrapServers)
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  kafkaConfig.BootstrapServers,
+		"group.id":           kafkaConfig.GroupID,
+		"auto.offset.reset":  kafkaConfig.AutoOffsetReset,
+		"enable.auto.commit": kafkaConfig.EnableAutoCommit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka consumer: %w", err)
+	}
+
+	// Subscribe to topics
+	topics := append([]string(nil), kafkaConfig.Topics...)
+	if kafkaConfig.PriorityTopic != "" {
+		topics = append(topics, kafkaConfig.PriorityTopic)
+	}
+	slog.Info("Subscribing to topics", "topics", topics)
+	if err := consumer.SubscribeTopics(topics, nil); err != nil {
+		consumer.Close()
+		return nil, fmt.Errorf("failed to subscribe to topic: %w", err)
+	}
+	return consumer, nil
+}
+
+// reconnectConsumer closes consumer (after all brokers went down or a failed seek) and
+// creates a new one after waiting backoff, doubling the wait (up to ReconnectMaxBackoff)
+// while creation fails. Returns nil if stopping is closed first.
+func reconnectConsumer(consumer *kafka.Consumer, kafkaConfig *KafkaConfig, backoff time.Duration,
+	stopping <-chan struct{}) *kafka.Consumer {
+	if err := consumer.Close(); err != nil {
+		slog.Error("Error closing Kafka consumer", "error", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		slog.Warn("Reconnecting to Kafka", "backoff", backoff, "attempt", attempt)
+		select {
+		case <-stopping:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		consumer, err := newConsumer(kafkaConfig)
+		if err == nil {
+			return consumer
+		}
+		slog.Error("Kafka reconnect failed", "error", err)
+		backoff = min(backoff*2, kafkaConfig.ReconnectMaxBackoff)
+	}
+}
+
+// closed reports whether ch has been closed, without blocking
+func closed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
 
-			switch e := ev.(type) {
-			case *kafka.Message:
-				fmt.Printf("\n=== Received transcript event ===\n")
+// pollInto blocks up to PollTimeout for the first event (not at all if buffer already
+// holds messages), then drains whatever else is ready without waiting, until buffer holds
+// bufferSize messages. Returns false if all brokers are down, so the caller reconnects.
+func pollInto(consumer kafkaConsumer, kafkaConfig *KafkaConfig, buffer *PriorityBuffer, bufferSize int, health *health.Health) bool {
+	timeoutMs := int(kafkaConfig.PollTimeout.Milliseconds())
+	if buffer.Len() > 0 {
+		timeoutMs = 0
+	}
 
-				// Parse the event
-				var event TranscriptEvent
-				if err := json.Unmarshal(e.Value, &event); err != nil {
-					fmt.Printf("Error parsing message: %v\n", err)
-					continue
-				}
+	for buffer.Len() < bufferSize {
+		ev := consumer.Poll(timeoutMs)
+		if ev == nil {
+			return true
+		}
+		timeoutMs = 0
 
-				fmt.Printf("Processing: %s - %s - Lecture %d\n",
-					event.ClassName, event.LectureTitle, event.LectureNumber)
+		switch e := ev.(type) {
+		case *kafka.Message:
+			health.MarkOK("kafka")
+			buffer.Push(NewPendingMessage(e, kafkaConfig))
 
-				if err := process(session, embeddingModel, &event); err != nil {
-					fmt.Printf("Error processing transcript: %v\n", err)
-					continue
-				}
+		case kafka.Error:
+			health.MarkFailed("kafka")
+			slog.Error("Kafka error", "error", e)
+			if e.Code() == kafka.ErrAllBrokersDown {
+				return false
+			}
+		}
+	}
+	return true
+}
 
-				fmt.Println("Successfully processed transcript")
+// shutdown closes the consumer, producer, model, and Cassandra session in order, exiting
+// non-zero if that takes longer than timeout
+func shutdown(consumer *kafka.Consumer, publisher *CompletionPublisher, embeddingModel *EmbeddingModel,
+	store *CassandraStore, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
 
-			case kafka.Error:
-				fmt.Fprintf(os.Stderr, "Error: %v\n", e)
-				if e.Code() == kafka.ErrAllBrokersDown {
-					run = false
-				}
+		// nil if shutdown interrupted a reconnect, which already closed the consumer
+		if consumer != nil {
+			slog.Info("Closing Kafka consumer")
+			if err := consumer.Close(); err != nil {
+				slog.Error("Error closing Kafka consumer", "error", err)
 			}
 		}
+
+		if publisher != nil {
+			slog.Info("Closing Kafka producer")
+			publisher.Close()
+		}
+
+		slog.Info("Closing embedding model")
+		if err := embeddingModel.Close(); err != nil {
+			slog.Error("Error closing embedding model", "error", err)
+		}
+
+		slog.Info("Closing Cassandra session")
+		store.Session().Close()
+	}()
+
+	select {
+	case <-done:
+		slog.Info("Shutdown complete")
+	case <-time.After(timeout):
+		slog.Error("Shutdown did not finish in time, exiting", "timeout", timeout)
+		os.Exit(1)
+	}
+}
+
+// handleMessage processes one consumed transcript event and commits it on success.
+// Returns false if processing failed and the message should be redelivered.
+func handleMessage(ctx context.Context, consumer kafkaConsumer, kafkaConfig *KafkaConfig, store *CassandraStore,
+	embeddingModel *EmbeddingModel, processorConfig *ProcessorConfig, publisher *CompletionPublisher,
+	health *health.Health, attempts failedAttempts, pending *PendingMessage) bool {
+
+	// Parse the event
+	if pending.ParseErr != nil {
+		slog.Error("Rejecting message", "error", pending.ParseErr)
+		// A malformed or incomplete message will never succeed, commit so it isn't redelivered
+		commitMessage(consumer, kafkaConfig, pending.Message)
+		return true
+	}
+	event := pending.Event
+	logger := eventLogger(&event)
+
+	logger.Info("Processing transcript", "lecture_title", event.LectureTitle,
+		"lecture_number", event.LectureNumber, "priority", pending.Priority)
+
+	result, err := process(ctx, store, embeddingModel, processorConfig, publisher, &event)
+	if err != nil {
+		if isCassandraConnectivityError(err) {
+			health.MarkFailed("cassandra")
+		} else {
+			health.MarkOK("cassandra")
+		}
+		return handleFailure(consumer, kafkaConfig, attempts, pending, err)
+	}
+	health.MarkOK("cassandra")
+	attempts.forget(pending)
+
+	logger.Info("Successfully processed transcript",
+		"sentence_count", result.Sentences, "chunk_count", result.Chunks, "total_tokens", result.TotalTokens,
+		"min_chunk_tokens", result.MinChunkTokens, "max_chunk_tokens", result.MaxChunkTokens,
+		"duration_ms", result.Duration.Milliseconds())
+	commitMessage(consumer, kafkaConfig, pending.Message)
+	return true
+}
+
+// handleFailure decides what happens to a message whose processing failed. A transient
+// failure leaves the offset uncommitted so the caller rewinds the partition to it, until
+// the message has failed MaxRetries times. A permanent failure, or one out of retries, is
+// logged and committed so the partition moves on. Returns true if the message was committed.
+func handleFailure(consumer kafkaConsumer, kafkaConfig *KafkaConfig, attempts failedAttempts,
+	pending *PendingMessage, err error) bool {
+	logger := eventLogger(&pending.Event)
+
+	if !isTransientError(err) {
+		// Redelivery would fail the same way, so commit rather than block the partition
+		logger.Error("Rejecting message", "error", err)
+		attempts.forget(pending)
+		commitMessage(consumer, kafkaConfig, pending.Message)
+		return true
+	}
+
+	failures := attempts.record(pending)
+	if kafkaConfig.MaxRetries > 0 && failures > kafkaConfig.MaxRetries {
+		logger.Error("Giving up on message after repeated failures", "attempts", failures, "error", err)
+		attempts.forget(pending)
+		commitMessage(consumer, kafkaConfig, pending.Message)
+		return true
+	}
+
+	logger.Error("Error processing transcript, will retry", "attempt", failures, "error", err)
+	return false
+}
+
+// isTransientError reports whether a processing failure may succeed on redelivery:
+// Cassandra unreachable or timing out, a Kafka error, or a cancelled or expired context.
+// Anything else (a missing or empty transcript, a NaN embedding, an infeasible chunking
+// config, a schema mismatch) fails the same way every time.
+func isTransientError(err error) bool {
+	var kafkaErr kafka.Error
+	return isCassandraConnectivityError(err) ||
+		errors.As(err, &kafkaErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled)
+}
+
+// offsetKey identifies one message of one partition
+type offsetKey struct {
+	topic     string
+	partition int32
+	offset    kafka.Offset
+}
+
+// failedAttempts counts how often each offset has failed, so redeliveries can be capped
+type failedAttempts map[offsetKey]int
+
+// key returns the offset of pending's message
+func (a failedAttempts) key(pending *PendingMessage) offsetKey {
+	tp := pending.Message.TopicPartition
+	key := offsetKey{partition: tp.Partition, offset: tp.Offset}
+	if tp.Topic != nil {
+		key.topic = *tp.Topic
+	}
+	return key
+}
+
+// record counts another failure of pending and returns how many it has had
+func (a failedAttempts) record(pending *PendingMessage) int {
+	key := a.key(pending)
+	a[key]++
+	return a[key]
+}
+
+// forget drops the count of pending once it is committed
+func (a failedAttempts) forget(pending *PendingMessage) {
+	delete(a, a.key(pending))
+}
+
+// rewindPartition seeks the partition of a failed message back to its offset and drops
+// the later messages already buffered from it, so the next polls redeliver them in order.
+// Returns false if the seek failed.
+func rewindPartition(consumer kafkaConsumer, buffer *PriorityBuffer, pending *PendingMessage) bool {
+	buffer.DropPartition(pending)
+	if err := consumer.Seek(pending.Message.TopicPartition, 0); err != nil {
+		slog.Error("Failed to rewind partition", "partition", pending.Message.TopicPartition, "error", err)
+		return false
+	}
+	return true
+}
+
+// commitMessage commits the offset of msg when auto-commit is disabled
+func commitMessage(consumer kafkaConsumer, kafkaConfig *KafkaConfig, msg *kafka.Message) {
+	if kafkaConfig.EnableAutoCommit {
+		return
 	}
+	if _, err := consumer.CommitMessage(msg); err != nil {
+		slog.Error("Failed to commit offset", "partition", msg.TopicPartition, "error", err)
+	}
+}
+
+// eventLogger returns a logger that tags records with the event's lecture
+func eventLogger(event *TranscriptEvent) *slog.Logger {
+	return slog.With("class_name", event.ClassName, "professor", event.Professor,
+		"semester", event.Semester, "url", event.URL)
 }
 
+// ErrEmptyTranscript is returned when a transcript has no text, so nothing could be ingested
+var ErrEmptyTranscript = errors.New("transcript text is empty")
+
 // fetches a transcript from Cassandra and processes it
-func process(session *gocql.Session, embeddingModel *EmbeddingModel, event *TranscriptEvent) error {
+func process(ctx context.Context, store *CassandraStore, embeddingModel *EmbeddingModel, processorConfig *ProcessorConfig,
+	publisher *CompletionPublisher, event *TranscriptEvent) (*ProcessResult, error) {
+	start := time.Now()
+	logger := eventLogger(event)
+
 	// Fetch transcript from Cassandra
-	transcript, err := FetchTranscriptByKey(session, event.ClassName, event.Professor, event.Semester, event.URL)
+	transcript, err := store.FetchTranscriptByKey(ctx, event.ClassName, event.Professor, event.Semester, event.URL)
 	if err != nil {
-		return fmt.Errorf("failed to fetch transcript: %w", err)
+		return nil, fmt.Errorf("failed to fetch transcript: %w", err)
+	}
+	logger.Info("Retrieved transcript", "char_count", len(transcript.TranscriptText))
+
+	chunks, untimed, err := embedTranscript(ctx, store, embeddingModel, processorConfig, event, transcript.TranscriptText)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := buildEmbeddingsRows(event, chunks, untimed, embeddingModel, processorConfig)
+	if err := storeEmbeddingsRows(ctx, store, processorConfig, event, rows); err != nil {
+		return nil, err
+	}
+	if err := storeSentenceEmbeddings(ctx, store, processorConfig, event, chunks, embeddingModel); err != nil {
+		return nil, err
+	}
+
+	// Notify downstream services. The chunks are already stored, so a failed publish is
+	// logged rather than failing (and reprocessing) the whole transcript.
+	err = publisher.Publish(TranscriptProcessedEvent{
+		ClassName:  event.ClassName,
+		Professor:  event.Professor,
+		Semester:   event.Semester,
+		URL:        event.URL,
+		ChunkCount: len(chunks),
+		ModelName:  embeddingModel.config.ModelName,
+	})
+	if err != nil {
+		logger.Warn("Failed to publish completion event", "error", err)
+	}
+
+	return newProcessResult(chunks, time.Since(start)), nil
+}
+
+// newProcessResult summarizes the chunks produced for one transcript
+func newProcessResult(chunks []*Chunk, duration time.Duration) *ProcessResult {
+	result := &ProcessResult{Chunks: len(chunks), Duration: duration}
+	for i, chunk := range chunks {
+		result.Sentences += chunk.NumSentences
+		result.TotalTokens += chunk.TokenCount
+		if i == 0 || chunk.TokenCount < result.MinChunkTokens {
+			result.MinChunkTokens = chunk.TokenCount
+		}
+		result.MaxChunkTokens = max(result.MaxChunkTokens, chunk.TokenCount)
+	}
+	return result
+}
+
+// embedTranscript runs the core pipeline on a transcript: parse frames, extract and embed
+// sentences, chunk them, and embed the chunks. Reports whether the transcript had no
+// timestamps, or ErrEmptyTranscript for blank text. store is only used for the sentence
+// cache and may be nil to skip it.
+func embedTranscript(ctx context.Context, store *CassandraStore, embeddingModel *EmbeddingModel, processorConfig *ProcessorConfig,
+	event *TranscriptEvent, transcriptText string) ([]*Chunk, bool, error) {
+	logger := eventLogger(event)
+
+	// Otherwise it yields zero chunks and is reported as a success
+	if strings.TrimSpace(transcriptText) == "" {
+		return nil, false, fmt.Errorf("%w: %s", ErrEmptyTranscript, event.URL)
 	}
-	fmt.Printf("\tRetrieved transcript (%d characters)\n", len(transcript.TranscriptText))
 
 	// Parse SRT into frames
-	frames := ParseSRT(transcript.TranscriptText)
-	fmt.Printf("\tParsed %d frames from SRT\n", len(frames))
+	frames, untimed := ParseTranscript(transcriptText, TranscriptOptions{
+		DetectPlainText:      processorConfig.DetectPlainText,
+		MergeDuplicateFrames: processorConfig.MergeDuplicateFrames,
+		SpeakerPatterns:      processorConfig.SpeakerPatterns,
+	})
+	if untimed {
+		logger.Info("Parsed frames from plain text (no timestamps)", "frame_count", len(frames), "untimed", true)
+	} else {
+		logger.Info("Parsed frames from SRT", "frame_count", len(frames))
+	}
 
 	// Extract sentences from frames
-	sentences := embeddingModel.ExtractSentencesFromFrames(frames)
-	fmt.Printf("\tExtracted %d sentences\n", len(sentences))
+	chunkingCfg := LoadChunkingConfig()
+	chunkingCfg.Resplit = embeddingModel.ResplitSentence
+	sentences := embeddingModel.ExtractSentencesFromFrames(frames, nil, chunkingCfg.MaxSize)
+	logger.Info("Extracted sentences", "sentence_count", len(sentences))
 
-	// Embed sentences
-	if err := embeddingModel.EmbedSentences(sentences); err != nil {
-		return fmt.Errorf("failed to embed sentences: %w", err)
+	// Embed sentences, reusing embeddings from the last run of this lecture if enabled
+	if embeddingModel.config.SentenceCache && store != nil {
+		cache := store.LectureEmbeddingCache(event.ClassName, event.Professor, event.Semester, event.URL)
+		hits, err := embeddingModel.EmbedSentencesCached(ctx, sentences, cache)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to embed sentences: %w", err)
+		}
+		logger.Info("Embedded sentences", "sentence_count", len(sentences), "cache_hits", hits)
+	} else {
+		if err := embeddingModel.EmbedSentences(sentences); err != nil {
+			return nil, false, fmt.Errorf("failed to embed sentences: %w", err)
+		}
+		logger.Info("Embedded sentences", "sentence_count", len(sentences))
 	}
-	fmt.Printf("\tEmbedded %d sentences\n", len(sentences))
 
 	// Perform semantic chunking
-	chunkingCfg := DefaultChunkingConfig()
-	chunks, err := chunkingCfg.ExtractChunksFromSentences(sentences)
+	chunks, err := chunkingCfg.Chunk(sentences)
 	if err != nil {
-		return fmt.Errorf("failed to extract chunks: %w", err)
+		return nil, false, fmt.Errorf("failed to extract chunks: %w", err)
 	}
-	fmt.Printf("\tCreated %d chunks\n", len(chunks))
+	logger.Info("Created chunks", "chunk_count", len(chunks))
 
 	// Embed chunks
 	if err := embeddingModel.EmbedChunks(chunks); err != nil {
-		return fmt.Errorf("failed to embed chunks: %w", err)
+		return nil, false, fmt.Errorf("failed to embed chunks: %w", err)
 	}
-	fmt.Printf("\tEmbedded %d chunks\n", len(chunks))
+	logger.Info("Embedded chunks", "chunk_count", len(chunks))
 
-	// Store chunks in Cassandra embeddings table
-	fmt.Printf("\tInserting %d chunks into Cassandra...\n", len(chunks))
+	if rate, lookups := embeddingModel.CacheHitRate(); lookups > 0 {
+		logger.Info("Embedding cache hit rate", "cache_hit_rate", rate, "cache_lookups", lookups)
+	}
+
+	// Sample embedding statistics to catch model drift or a broken deploy
+	if rate := embeddingModel.config.StatsSampleRate; rate > 0 && rand.Float64() < rate {
+		chunkEmbeddings := make([][]float32, len(chunks))
+		for i, chunk := range chunks {
+			chunkEmbeddings[i] = chunk.Embedding
+		}
+		stats := ComputeEmbeddingStats(chunkEmbeddings)
+		logger.Info("Embedding stats", "count", stats.Count, "mean_norm", stats.MeanNorm,
+			"mean_pairwise_sim", stats.MeanPairwiseSimilarity, "near_zero", stats.NearZeroFraction)
+	}
+
+	return chunks, untimed, nil
+}
+
+// buildEmbeddingsRows turns a lecture's chunks into embeddings table rows
+func buildEmbeddingsRows(event *TranscriptEvent, chunks []*Chunk, untimed bool, embeddingModel *EmbeddingModel,
+	processorConfig *ProcessorConfig) []*EmbeddingsRow {
+	lectureOrder := event.LectureNumber
+	if processorConfig.NormalizeLectureOrder {
+		lectureOrder = NormalizeLectureOrder(event.LectureNumber, event.LectureTitle)
+	}
+
+	// Extract top keywords per chunk for lexical pre-filtering
+	var chunkKeywords [][]string
+	if processorConfig.KeywordsPerChunk > 0 {
+		chunkTexts := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			chunkTexts[i] = chunk.Text
+		}
+		chunkKeywords = ExtractKeywords(chunkTexts, processorConfig.KeywordsPerChunk)
+	}
+
+	rows := make([]*EmbeddingsRow, len(chunks))
 	for i, chunk := range chunks {
-		row := &EmbeddingsRow{
-			ClassName:        event.ClassName,  // partition key
-			Professor:        event.Professor,  // partition key
-			Semester:         event.Semester,   // partition key
-			URL:              event.URL,        // cluster key
-			ChunkIndex:       chunk.ChunkIndex, // cluster key
-			ChunkText:        chunk.Text,
-			Embedding:        chunk.Embedding, // embedding search
-			TokenCount:       chunk.TokenCount,
-			LectureTitle:     event.LectureTitle,
-			LectureTimestamp: chunk.StartTime,
-		}
-
-		// insert into embeddings table (RAG)
-		if err := InsertEmbedding(session, row); err != nil {
-			return fmt.Errorf("failed to insert chunk %d: %w", i, err)
-		}
-
-		// Insert into inverted index table (Keyword matching)
-		terms := WordsFromText(chunk.Text)
+		rows[i] = &EmbeddingsRow{
+			ClassName:         event.ClassName,  // partition key
+			Professor:         event.Professor,  // partition key
+			Semester:          event.Semester,   // partition key
+			URL:               event.URL,        // cluster key
+			ChunkIndex:        chunk.ChunkIndex, // cluster key
+			ChunkText:         chunk.Text,
+			Embedding:         chunk.Embedding, // embedding search
+			TokenCount:        chunk.TokenCount,
+			LectureTitle:      event.LectureTitle,
+			LectureOrder:      lectureOrder,
+			LectureTimestamp:  chunk.StartTime,
+			LectureStartMs:    chunk.StartMillis,
+			LectureEndTime:    chunk.EndTime,
+			ContinuesPrevious: chunk.ContinuesPrevious,
+			Untimed:           untimed,
+			ModelName:         embeddingModel.config.ModelName,
+			EmbeddingDim:      len(chunk.Embedding),
+		}
+		if chunkKeywords != nil {
+			rows[i].Keywords = chunkKeywords[i]
+		}
+	}
+	return rows
+}
+
+// storeEmbeddingsRows writes a lecture's rows to the embeddings and inverted index tables,
+// first clearing its previous chunks in reprocess mode
+func storeEmbeddingsRows(ctx context.Context, store *CassandraStore, processorConfig *ProcessorConfig,
+	event *TranscriptEvent, rows []*EmbeddingsRow) error {
+	logger := eventLogger(event)
+
+	// Store chunks in Cassandra embeddings table
+	logger.Info("Inserting chunks into Cassandra", "chunk_count", len(rows))
+
+	// Clear chunks from a previous run of this lecture. Done here rather than up front
+	// so a failure earlier in processing leaves the old chunks searchable.
+	if processorConfig.ReprocessMode {
+		if err := store.DeleteEmbeddingsForURL(ctx, event.ClassName, event.Professor, event.Semester, event.URL); err != nil {
+			return err
+		}
+	}
+
+	// insert into embeddings table (RAG)
+	if err := store.InsertEmbeddings(ctx, rows); err != nil {
+		return err
+	}
+
+	// Insert into inverted index table (Keyword matching)
+	for i, row := range rows {
+		terms := WordsFromText(row.ChunkText)
 		for _, term := range terms {
-			if err := InsertInvertedIndexTerm(session, term, row); err != nil {
-				return fmt.Errorf("\t\tWarning: failed to insert term '%s' for chunk %d: %v\n", term, i, err)
+			if err := store.InsertInvertedIndexTerm(ctx, term, row); err != nil {
+				return fmt.Errorf("failed to insert term %q for chunk %d: %w", term, i, err)
+			}
+		}
+	}
+	logger.Info("Inserted chunks", "chunk_count", len(rows))
+	return nil
+}
+
+// storeSentenceEmbeddings writes the embedding of every sentence in chunks to the
+// sentence_embeddings table, if StoreSentenceEmbeddings is enabled. In reprocess mode the
+// lecture's previous sentence rows are deleted first.
+func storeSentenceEmbeddings(ctx context.Context, store *CassandraStore, processorConfig *ProcessorConfig,
+	event *TranscriptEvent, chunks []*Chunk, embeddingModel *EmbeddingModel) error {
+	if !processorConfig.StoreSentenceEmbeddings {
+		return nil
+	}
+	logger := eventLogger(event)
+
+	var rows []*SentenceEmbeddingsRow
+	for _, chunk := range chunks {
+		for i, sentence := range chunk.Sentences {
+			embedding := chunk.SentenceEmbeddings[i]
+			if embeddingModel.config.Normalize {
+				embedding = NormalizeL2(embedding)
 			}
+			rows = append(rows, &SentenceEmbeddingsRow{
+				ClassName:        event.ClassName,
+				Professor:        event.Professor,
+				Semester:         event.Semester,
+				URL:              event.URL,
+				SentenceIndex:    len(rows),
+				ChunkIndex:       chunk.ChunkIndex,
+				SentenceText:     sentence.Text,
+				Embedding:        embedding,
+				LectureTimestamp: sentence.StartTime,
+				LectureStartMs:   sentence.StartMillis,
+				ModelName:        embeddingModel.config.ModelName,
+			})
 		}
 	}
-	fmt.Printf("\tInserted %d chunks to database\n", len(chunks))
 
+	if processorConfig.ReprocessMode {
+		if err := store.DeleteSentenceEmbeddingsForURL(ctx, event.ClassName, event.Professor, event.Semester, event.URL); err != nil {
+			return err
+		}
+	}
+	if err := store.InsertSentenceEmbeddings(ctx, rows); err != nil {
+		return err
+	}
+	logger.Info("Inserted sentence embeddings", "sentence_count", len(rows))
 	return nil
 }
+
+// Title numbers, in order of preference: "Week 3 Lecture 7" is lecture 7, not week 3
+var lectureNumberPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(?:lecture|lec|class)\s*#?\s*(\d+)`),
+	regexp.MustCompile(`(?i)\b(?:session|week|day|part)\s*#?\s*(\d+)`),
+}
+
+// NormalizeLectureOrder returns lectureNumber if it is set, otherwise a number parsed
+// from the title (e.g. "Lecture 12: Kafka" -> 12), preferring a lecture number over a
+// week, day, part or session number. Returns 0 if neither is available.
+// Duplicate orders are broken by url, the embeddings clustering key.
+func NormalizeLectureOrder(lectureNumber int, lectureTitle string) int {
+	if lectureNumber > 0 {
+		return lectureNumber
+	}
+
+	for _, pattern := range lectureNumberPatterns {
+		match := pattern.FindStringSubmatch(lectureTitle)
+		if len(match) > 1 {
+			if n, err := strconv.Atoi(match[1]); err == nil {
+				return n
+			}
+		}
+	}
+
+	return 0
+}
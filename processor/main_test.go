@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"piazza-bot/shared/health"
+)
+
+// fakePartitionConsumer serves one partition's log from a fetch position that Seek moves
+type fakePartitionConsumer struct {
+	log       []*kafka.Message
+	position  int
+	delivered []kafka.Offset
+	committed []kafka.Offset
+}
+
+func newFakePartitionConsumer(count int) *fakePartitionConsumer {
+	topic := "transcript-events"
+	c := &fakePartitionConsumer{}
+	for i := 0; i < count; i++ {
+		c.log = append(c.log, &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: kafka.Offset(i)},
+			Value:          []byte(`{"class_name":"CS 537","professor":"P","semester":"S","url":"u"}`),
+		})
+	}
+	return c
+}
+
+func (c *fakePartitionConsumer) Poll(int) kafka.Event {
+	if c.position >= len(c.log) {
+		return nil
+	}
+	msg := c.log[c.position]
+	c.position++
+	c.delivered = append(c.delivered, msg.TopicPartition.Offset)
+	return msg
+}
+
+func (c *fakePartitionConsumer) CommitMessage(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+	c.committed = append(c.committed, msg.TopicPartition.Offset)
+	return nil, nil
+}
+
+func (c *fakePartitionConsumer) Seek(partition kafka.TopicPartition, _ int) error {
+	c.position = int(partition.Offset)
+	return nil
+}
+
+func TestFailedOffsetIsRedeliveredBeforeLaterOffsetsCommit(t *testing.T) {
+	consumer := newFakePartitionConsumer(3)
+	kafkaConfig := &KafkaConfig{}
+	buffer := NewPriorityBuffer()
+	h := health.New(0, "kafka")
+
+	// Offset 0 fails on its first attempt only
+	failures := map[kafka.Offset]int{0: 1}
+
+	for i := 0; i < 10 && len(consumer.committed) < 3; i++ {
+		pollInto(consumer, kafkaConfig, buffer, 3, h)
+		pending := buffer.Pop()
+		if pending == nil {
+			continue
+		}
+
+		offset := pending.Message.TopicPartition.Offset
+		if failures[offset] > 0 {
+			failures[offset]--
+			if !rewindPartition(consumer, buffer, pending) {
+				t.Fatal("rewindPartition failed")
+			}
+			continue
+		}
+		commitMessage(consumer, kafkaConfig, pending.Message)
+	}
+
+	if want := []kafka.Offset{0, 1, 2}; !reflect.DeepEqual(consumer.committed, want) {
+		t.Errorf("committed %v, want %v", consumer.committed, want)
+	}
+	if want := []kafka.Offset{0, 1, 2, 0, 1, 2}; !reflect.DeepEqual(consumer.delivered, want) {
+		t.Errorf("delivered %v, want %v", consumer.delivered, want)
+	}
+}
+
+func TestRewindPartitionKeepsOtherPartitions(t *testing.T) {
+	topic := "transcript-events"
+	kafkaConfig := &KafkaConfig{}
+	buffer := NewPriorityBuffer()
+	for _, tp := range []kafka.TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: 5},
+		{Topic: &topic, Partition: 0, Offset: 6},
+		{Topic: &topic, Partition: 1, Offset: 9},
+	} {
+		buffer.Push(NewPendingMessage(&kafka.Message{TopicPartition: tp, Value: []byte(`{}`)}, kafkaConfig))
+	}
+
+	failed := buffer.Pop()
+	if !rewindPartition(&fakePartitionConsumer{}, buffer, failed) {
+		t.Fatal("rewindPartition failed")
+	}
+
+	if buffer.Len() != 1 {
+		t.Fatalf("Len() = %d, want 1", buffer.Len())
+	}
+	if next := buffer.Pop(); next.Message.TopicPartition.Partition != 1 {
+		t.Errorf("remaining message is from partition %d, want 1", next.Message.TopicPartition.Partition)
+	}
+}
+
+// runFailing drives consumer's partition through pollInto and handleFailure, failing each
+// attempt of an offset in fail with its error and committing everything else
+func runFailing(consumer *fakePartitionConsumer, kafkaConfig *KafkaConfig, fail map[kafka.Offset]error) {
+	buffer := NewPriorityBuffer()
+	attempts := make(failedAttempts)
+	h := health.New(0, "kafka")
+
+	for i := 0; i < 20 && len(consumer.committed) < len(consumer.log); i++ {
+		pollInto(consumer, kafkaConfig, buffer, len(consumer.log), h)
+		pending := buffer.Pop()
+		if pending == nil {
+			continue
+		}
+
+		if err := fail[pending.Message.TopicPartition.Offset]; err != nil {
+			if !handleFailure(consumer, kafkaConfig, attempts, pending, err) {
+				rewindPartition(consumer, buffer, pending)
+			}
+			continue
+		}
+		commitMessage(consumer, kafkaConfig, pending.Message)
+	}
+}
+
+func TestPermanentErrorIsCommittedWithoutRedelivery(t *testing.T) {
+	consumer := newFakePartitionConsumer(3)
+	err := fmt.Errorf("failed to fetch transcript: %w", ErrTranscriptNotFound)
+	runFailing(consumer, &KafkaConfig{MaxRetries: 5}, map[kafka.Offset]error{0: err})
+
+	if want := []kafka.Offset{0, 1, 2}; !reflect.DeepEqual(consumer.committed, want) {
+		t.Errorf("committed %v, want %v", consumer.committed, want)
+	}
+	if want := []kafka.Offset{0, 1, 2}; !reflect.DeepEqual(consumer.delivered, want) {
+		t.Errorf("delivered %v, want %v", consumer.delivered, want)
+	}
+}
+
+func TestTransientErrorIsRetriedUpToMaxRetries(t *testing.T) {
+	consumer := newFakePartitionConsumer(2)
+	runFailing(consumer, &KafkaConfig{MaxRetries: 2}, map[kafka.Offset]error{0: context.DeadlineExceeded})
+
+	if want := []kafka.Offset{0, 1}; !reflect.DeepEqual(consumer.committed, want) {
+		t.Errorf("committed %v, want %v", consumer.committed, want)
+	}
+	// The first attempt and two retries
+	if want := []kafka.Offset{0, 1, 0, 1, 0, 1}; !reflect.DeepEqual(consumer.delivered, want) {
+		t.Errorf("delivered %v, want %v", consumer.delivered, want)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("insert: %w", gocql.ErrNoConnections), true},
+		{fmt.Errorf("fetch: %w", context.DeadlineExceeded), true},
+		{kafka.NewError(kafka.ErrTransport, "broker down", false), true},
+		{fmt.Errorf("fetch: %w", ErrTranscriptNotFound), false},
+		{fmt.Errorf("chunk: %w", ErrNonFiniteEmbedding), false},
+		{fmt.Errorf("%w: u", ErrEmptyTranscript), false},
+		{errors.New(`unknown ScoringMode "median"`), false},
+	}
+
+	for _, tt := range tests {
+		if got := isTransientError(tt.err); got != tt.want {
+			t.Errorf("isTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeLectureOrder(t *testing.T) {
+	tests := []struct {
+		name          string
+		lectureNumber int
+		lectureTitle  string
+		want          int
+	}{
+		{"number set", 4, "Lecture 9: Paging", 4},
+		{"number set, no title", 4, "", 4},
+		{"lecture in title", 0, "Lecture 12: Kafka", 12},
+		{"abbreviated", 0, "lec#3 - Scheduling", 3},
+		{"negative number falls back to title", -1, "Class 5", 5},
+		{"lecture preferred over week", 0, "Week 3 Lecture 7", 7},
+		{"lecture preferred over part", 0, "Part 2 of Lecture 11", 11},
+		{"week only", 0, "Week 3: Review", 3},
+		{"first lecture number wins", 0, "Lecture 4 (recap of Lecture 2)", 4},
+		{"lecture zero", 0, "Lecture 0: Logistics", 0},
+		{"unlabelled number", 0, "CS 537 Midterm Review", 0},
+		{"no title", 0, "", 0},
+		{"word containing a keyword", 0, "Electure 8", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeLectureOrder(tt.lectureNumber, tt.lectureTitle); got != tt.want {
+				t.Errorf("NormalizeLectureOrder(%d, %q) = %d, want %d", tt.lectureNumber, tt.lectureTitle, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeLectureOrderDuplicates(t *testing.T) {
+	// Two recordings of the same lecture share an order, the url breaks the tie when stored
+	a := NormalizeLectureOrder(0, "Lecture 6 (section 1)")
+	b := NormalizeLectureOrder(0, "Lecture 6 (section 2)")
+	if a != 6 || b != 6 {
+		t.Errorf("orders = %d, %d, want 6, 6", a, b)
+	}
+}
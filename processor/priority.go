@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// PendingMessage is a consumed Kafka message waiting to be processed
+type PendingMessage struct {
+	Message  *kafka.Message
+	Event    TranscriptEvent
+	ParseErr error  // set if the message value isn't a valid, complete TranscriptEvent
+	Priority int    // higher is processed first
+	queueKey string // topic/partition the message was read from
+	seq      int    // arrival order, breaks priority ties
+}
+
+// NewPendingMessage decodes msg and assigns its priority. Messages on the configured
+// priority topic are treated as at least priority 1.
+func NewPendingMessage(msg *kafka.Message, kafkaConfig *KafkaConfig) *PendingMessage {
+	pending := &PendingMessage{Message: msg}
+	pending.ParseErr = json.Unmarshal(msg.Value, &pending.Event)
+	if pending.ParseErr == nil {
+		pending.ParseErr = pending.Event.validate()
+	}
+	pending.Priority = pending.Event.Priority
+
+	topic := ""
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+	if kafkaConfig.PriorityTopic != "" && topic == kafkaConfig.PriorityTopic && pending.Priority < 1 {
+		pending.Priority = 1
+	}
+
+	pending.queueKey = fmt.Sprintf("%s/%d", topic, msg.TopicPartition.Partition)
+	return pending
+}
+
+// PriorityBuffer holds consumed messages and releases the highest-priority one first.
+// Only the head of each partition is eligible, so messages within a partition keep their
+// offset order and committing one never skips an earlier, unprocessed offset.
+type PriorityBuffer struct {
+	queues  map[string][]*PendingMessage
+	size    int
+	nextSeq int
+}
+
+// NewPriorityBuffer returns an empty buffer
+func NewPriorityBuffer() *PriorityBuffer {
+	return &PriorityBuffer{
+		queues: make(map[string][]*PendingMessage),
+	}
+}
+
+// Push adds a message to the back of its partition's queue
+func (b *PriorityBuffer) Push(pending *PendingMessage) {
+	pending.seq = b.nextSeq
+	b.nextSeq++
+	b.queues[pending.queueKey] = append(b.queues[pending.queueKey], pending)
+	b.size++
+}
+
+// Pop removes and returns the highest-priority partition head (oldest on ties), or nil if empty
+func (b *PriorityBuffer) Pop() *PendingMessage {
+	var bestKey string
+	var best *PendingMessage
+	for key, queue := range b.queues {
+		head := queue[0]
+		if best == nil || head.Priority > best.Priority ||
+			(head.Priority == best.Priority && head.seq < best.seq) {
+			best = head
+			bestKey = key
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	if len(b.queues[bestKey]) == 1 {
+		delete(b.queues, bestKey)
+	} else {
+		b.queues[bestKey] = b.queues[bestKey][1:]
+	}
+	b.size--
+	return best
+}
+
+// DropPartition discards the buffered messages read from the same partition as pending,
+// for when that partition is rewound and they will be consumed again
+func (b *PriorityBuffer) DropPartition(pending *PendingMessage) {
+	b.size -= len(b.queues[pending.queueKey])
+	delete(b.queues, pending.queueKey)
+}
+
+// Len returns the number of buffered messages
+func (b *PriorityBuffer) Len() int {
+	return b.size
+}
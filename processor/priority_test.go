@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// pendingEvent builds a pending message for url with the given priority field
+func pendingEvent(kafkaConfig *KafkaConfig, topic string, partition int32, offset int, url string, priority int) *PendingMessage {
+	value := fmt.Sprintf(`{"class_name":"CS 537","professor":"P","semester":"S","url":%q,"priority":%d}`, url, priority)
+	return NewPendingMessage(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(offset)},
+		Value:          []byte(value),
+	}, kafkaConfig)
+}
+
+func TestPriorityBufferReturnsHighPriorityFirst(t *testing.T) {
+	kafkaConfig := &KafkaConfig{PriorityTopic: "transcript-events-priority"}
+	buffer := NewPriorityBuffer()
+	buffer.Push(pendingEvent(kafkaConfig, "transcript-events", 0, 0, "backfill-1", 0))
+	buffer.Push(pendingEvent(kafkaConfig, "transcript-events", 1, 0, "backfill-2", 0))
+	buffer.Push(pendingEvent(kafkaConfig, "transcript-events", 2, 0, "current-week", 5))
+	buffer.Push(pendingEvent(kafkaConfig, "transcript-events-priority", 0, 0, "priority-topic", 0))
+
+	var got []string
+	for buffer.Len() > 0 {
+		got = append(got, buffer.Pop().Event.URL)
+	}
+
+	want := []string{"current-week", "priority-topic", "backfill-1", "backfill-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("popped %q, want %q", got, want)
+	}
+	if buffer.Pop() != nil {
+		t.Error("Pop on an empty buffer should return nil")
+	}
+}
+
+func TestPriorityBufferKeepsPartitionOrder(t *testing.T) {
+	kafkaConfig := &KafkaConfig{}
+	buffer := NewPriorityBuffer()
+	// The urgent event sits behind a backfill in the same partition, so it can't jump it
+	buffer.Push(pendingEvent(kafkaConfig, "transcript-events", 0, 0, "backfill", 0))
+	buffer.Push(pendingEvent(kafkaConfig, "transcript-events", 0, 1, "urgent", 9))
+	buffer.Push(pendingEvent(kafkaConfig, "transcript-events", 1, 0, "other", 1))
+
+	var got []string
+	for buffer.Len() > 0 {
+		got = append(got, buffer.Pop().Event.URL)
+	}
+
+	want := []string{"other", "backfill", "urgent"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("popped %q, want %q", got, want)
+	}
+}
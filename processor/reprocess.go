@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
+)
+
+// ReprocessOptions configures a re-embedding run over every stored transcript
+type ReprocessOptions struct {
+	Enabled     bool
+	Concurrency int    // transcripts processed in parallel; model calls are still serialized
+	ResumeFile  string // holds the scan's page state between pages, "" disables resuming
+	PageSize    int
+}
+
+// registerReprocessFlags defines the reprocess-all flags, filled in by flag.Parse:
+//
+//	processor -reprocess-all [-concurrency 4] [-resume reprocess.state]
+func registerReprocessFlags() *ReprocessOptions {
+	var opts ReprocessOptions
+	flag.BoolVar(&opts.Enabled, "reprocess-all", false, "re-embed every transcript in Cassandra instead of consuming from Kafka")
+	flag.IntVar(&opts.Concurrency, "concurrency", 1, "transcripts processed in parallel (with -reprocess-all)")
+	flag.StringVar(&opts.ResumeFile, "resume", "reprocess.state", "file recording scan progress, so a crashed run continues where it left off (with -reprocess-all)")
+	flag.IntVar(&opts.PageSize, "page-size", 100, "transcripts read from Cassandra per page (with -reprocess-all)")
+	return &opts
+}
+
+// scanTranscriptKeysQuery lists transcript keys without their (large) text
+const scanTranscriptKeysQuery = `
+	SELECT class_name, professor, semester, url, lecture_number, lecture_title
+	FROM transcripts
+`
+
+// runReprocessAll scans the transcripts table page by page and runs process on every
+// row with the configured embedding model. Reprocess mode is forced on, so each lecture's
+// old chunks are replaced. After each page the scan's page state is written to
+// opts.ResumeFile; a later run with the same file starts from the next page, redoing at
+// most the page that was in flight. Failed transcripts are logged and skipped, and no
+// completion events are published. SIGINT/SIGTERM stop the run after the current page.
+// The sentence cache is disabled: new weights under an unchanged model name would
+// otherwise be served the old vectors.
+func runReprocessAll(opts ReprocessOptions) error {
+	cassandraConfig := LoadCassandraConfig()
+	embeddingConfig := LoadEmbeddingConfig()
+	embeddingConfig.SentenceCache = false
+	processorConfig := LoadProcessorConfig()
+	processorConfig.ReprocessMode = true
+
+	pageState, err := readResumeFile(opts.ResumeFile)
+	if err != nil {
+		return err
+	}
+	if pageState != nil {
+		slog.Info("Resuming reprocess", "resume_file", opts.ResumeFile)
+	}
+
+	slog.Info("Connecting to Cassandra", "hosts", cassandraConfig.CassandraHosts)
+	session, err := ConnectCassandra(cassandraConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Cassandra: %w", err)
+	}
+	defer session.Close()
+	store := NewCassandraStore(session, cassandraConfig)
+
+	slog.Info("Loading embedding model")
+	embeddingModel, err := InitEmbeddingModel(embeddingConfig)
+	if err != nil {
+		return fmt.Errorf("failed to load embedding model: %w", err)
+	}
+	defer embeddingModel.Close()
+
+	stopCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	concurrency := max(opts.Concurrency, 1)
+	var processed, failed atomic.Int64
+	for page := 1; ; page++ {
+		if stopCtx.Err() != nil {
+			slog.Info("Reprocess interrupted; rerun with the same -resume file to continue", "resume_file", opts.ResumeFile)
+			return nil
+		}
+
+		events, nextPageState, err := scanTranscriptPage(session, pageState, opts.PageSize)
+		if err != nil {
+			return err
+		}
+
+		// Work through the page with a fixed pool of workers
+		work := make(chan *TranscriptEvent)
+		var wg sync.WaitGroup
+		for w := 0; w < concurrency; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for event := range work {
+					logger := eventLogger(event)
+					// Transcripts aren't cancelled mid-way, so a stop never leaves a lecture half-written
+					if _, err := process(context.Background(), store, embeddingModel, processorConfig, nil, event); err != nil {
+						failed.Add(1)
+						logger.Error("Failed to reprocess transcript", "error", err)
+						continue
+					}
+					processed.Add(1)
+				}
+			}()
+		}
+		for _, event := range events {
+			work <- event
+		}
+		close(work)
+		wg.Wait()
+
+		slog.Info("Page done", "page", page, "processed", processed.Load(), "failed", failed.Load())
+
+		if len(nextPageState) == 0 {
+			break
+		}
+		pageState = nextPageState
+		if err := writeResumeFile(opts.ResumeFile, pageState); err != nil {
+			return err
+		}
+	}
+
+	if opts.ResumeFile != "" {
+		if err := os.Remove(opts.ResumeFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to remove resume file", "error", err)
+		}
+	}
+	slog.Info("Reprocess complete", "processed", processed.Load(), "failed", failed.Load())
+	return nil
+}
+
+// scanTranscriptPage reads one page of transcript keys starting at pageState (nil for
+// the first page) and returns them with the state of the next page, empty after the last
+func scanTranscriptPage(session *gocql.Session, pageState []byte, pageSize int) ([]*TranscriptEvent, []byte, error) {
+	iter := session.Query(scanTranscriptKeysQuery).PageSize(pageSize).PageState(pageState).Iter()
+	nextPageState := iter.PageState()
+
+	var events []*TranscriptEvent
+	var e TranscriptEvent
+	for iter.Scan(&e.ClassName, &e.Professor, &e.Semester, &e.URL, &e.LectureNumber, &e.LectureTitle) {
+		event := e
+		events = append(events, &event)
+		e = TranscriptEvent{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, nil, fmt.Errorf("error scanning transcripts: %w", err)
+	}
+	return events, nextPageState, nil
+}
+
+// readResumeFile returns the page state saved in path, or nil to start from the beginning
+func readResumeFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resume file: %w", err)
+	}
+	state, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid resume file %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// writeResumeFile saves the next page state to path, replacing it atomically
+func writeResumeFile(path string, pageState []byte) error {
+	if path == "" {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(hex.EncodeToString(pageState)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write resume file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write resume file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
+)
+
+// ClassKey identifies one class partition of the embeddings table
+type ClassKey struct {
+	ClassName string
+	Professor string
+	Semester  string
+}
+
+// SearchResult is a stored chunk scored against a query embedding
+type SearchResult struct {
+	Class            ClassKey // class the chunk belongs to
+	URL              string
+	ChunkIndex       int
+	ChunkText        string
+	LectureTitle     string
+	LectureTimestamp string
+	Score            float32 // cosine similarity to the query
+}
+
+const searchPartitionQuery = `
+	SELECT url, chunk_index, chunk_text, lecture_title, lecture_timestamp, embedding, model_name
+	FROM embeddings
+	WHERE class_name = ? AND professor = ? AND semester = ?
+`
+
+// SearchChunks scores every chunk in each class partition against queryEmbedding and
+// returns the global top K across all classes, highest score first. Chunks embedded by a
+// model other than modelName are skipped ("" compares against every chunk; rows written
+// before model_name existed are assumed compatible). With concurrent set, the partitions
+// are scanned in parallel.
+func SearchChunks(session *gocql.Session, queryEmbedding []float32, modelName string, classes []ClassKey, topK int, concurrent bool) ([]SearchResult, error) {
+	return searchClasses(classes, topK, concurrent, func(class ClassKey) ([]SearchResult, error) {
+		return searchPartition(session, queryEmbedding, modelName, class, topK)
+	})
+}
+
+// searchClasses runs search on each class, in parallel if concurrent is set, and merges
+// the results into the global top K
+func searchClasses(classes []ClassKey, topK int, concurrent bool,
+	search func(class ClassKey) ([]SearchResult, error)) ([]SearchResult, error) {
+	if topK <= 0 {
+		return nil, nil
+	}
+
+	perClass := make([][]SearchResult, len(classes))
+	errs := make([]error, len(classes))
+
+	if concurrent {
+		var wg sync.WaitGroup
+		for i, class := range classes {
+			wg.Add(1)
+			go func(i int, class ClassKey) {
+				defer wg.Done()
+				perClass[i], errs[i] = search(class)
+			}(i, class)
+		}
+		wg.Wait()
+	} else {
+		for i, class := range classes {
+			perClass[i], errs[i] = search(class)
+		}
+	}
+
+	var merged []SearchResult
+	for i, results := range perClass {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		merged = append(merged, results...)
+	}
+
+	sortResults(merged)
+	if len(merged) > topK {
+		merged = merged[:topK]
+	}
+	return merged, nil
+}
+
+// searchPartition scans one class partition and returns its top K chunks
+func searchPartition(session *gocql.Session, queryEmbedding []float32, modelName string, class ClassKey, topK int) ([]SearchResult, error) {
+	iter := session.Query(searchPartitionQuery, class.ClassName, class.Professor, class.Semester).Iter()
+
+	var results []SearchResult
+	var result SearchResult
+	var embedding []float32
+	var rowModel string
+	mismatched := 0
+	for iter.Scan(&result.URL, &result.ChunkIndex, &result.ChunkText,
+		&result.LectureTitle, &result.LectureTimestamp, &embedding, &rowModel) {
+		if modelName != "" && rowModel != "" && rowModel != modelName {
+			mismatched++
+			result, embedding, rowModel = SearchResult{}, nil, ""
+			continue
+		}
+
+		score, err := CosineSimilarity(queryEmbedding, embedding)
+		if err != nil {
+			// Skip rows with a missing or mismatched embedding
+			continue
+		}
+		result.Class = class
+		result.Score = score
+		results = append(results, result)
+		result, embedding, rowModel = SearchResult{}, nil, ""
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("error searching %s/%s/%s: %w", class.ClassName, class.Professor, class.Semester, err)
+	}
+	if mismatched > 0 {
+		slog.Warn("Skipped chunks embedded by a different model",
+			"class_name", class.ClassName, "model_name", modelName, "chunk_count", mismatched)
+	}
+
+	sortResults(results)
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	return results, nil
+}
+
+// sortResults orders results by descending score, breaking ties by class, URL, and chunk
+// index so merged output is deterministic
+func sortResults(results []SearchResult) {
+	sort.Slice(results, func(a, b int) bool {
+		ra, rb := results[a], results[b]
+		if ra.Score != rb.Score {
+			return ra.Score > rb.Score
+		}
+		if ra.Class != rb.Class {
+			if ra.Class.ClassName != rb.Class.ClassName {
+				return ra.Class.ClassName < rb.Class.ClassName
+			}
+			if ra.Class.Professor != rb.Class.Professor {
+				return ra.Class.Professor < rb.Class.Professor
+			}
+			return ra.Class.Semester < rb.Class.Semester
+		}
+		if ra.URL != rb.URL {
+			return ra.URL < rb.URL
+		}
+		return ra.ChunkIndex < rb.ChunkIndex
+	})
+}
+
+// SearchEmbeddings embeds the query text with EmbedQuery and returns the top K chunks of
+// one class by cosine similarity
+func SearchEmbeddings(session *gocql.Session, model *EmbeddingModel, text string,
+	className, professor, semester string, topK int) ([]SearchResult, error) {
+	queryEmbedding, err := model.EmbedQuery(text)
+	if err != nil {
+		return nil, err
+	}
+
+	class := ClassKey{ClassName: className, Professor: professor, Semester: semester}
+	return SearchChunks(session, queryEmbedding, model.config.ModelName, []ClassKey{class}, topK, false)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fakePartitions returns a search over in-memory class partitions that scores each
+// chunk's embedding against query, as searchPartition does
+func fakePartitions(query []float32, partitions map[ClassKey][][]float32) func(ClassKey) ([]SearchResult, error) {
+	return func(class ClassKey) ([]SearchResult, error) {
+		var results []SearchResult
+		for i, emb := range partitions[class] {
+			score, err := CosineSimilarity(query, emb)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, SearchResult{Class: class, URL: class.ClassName + "/lecture", ChunkIndex: i, Score: score})
+		}
+		sortResults(results)
+		return results, nil
+	}
+}
+
+func TestSearchClassesMergesTopK(t *testing.T) {
+	osClass := ClassKey{ClassName: "CS 537", Professor: "Arpaci-Dusseau", Semester: "Fall 2025"}
+	dbClass := ClassKey{ClassName: "CS 564", Professor: "Koutris", Semester: "Fall 2025"}
+	query := []float32{1, 0}
+	search := fakePartitions(query, map[ClassKey][][]float32{
+		osClass: {{1, 0}, {0, 1}, {0.6, 0.8}}, // scores 1, 0, 0.6
+		dbClass: {{0.8, 0.6}, {-1, 0}},        // scores 0.8, -1
+	})
+
+	type hit struct {
+		class      ClassKey
+		chunkIndex int
+	}
+	want := []hit{{osClass, 0}, {dbClass, 0}, {osClass, 2}}
+
+	for _, concurrent := range []bool{false, true} {
+		results, err := searchClasses([]ClassKey{osClass, dbClass}, 3, concurrent, search)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []hit
+		for i, r := range results {
+			got = append(got, hit{r.Class, r.ChunkIndex})
+			if i > 0 && r.Score > results[i-1].Score {
+				t.Errorf("concurrent=%v: results not in descending score order", concurrent)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("concurrent=%v: got %+v, want %+v", concurrent, got, want)
+		}
+	}
+}
+
+func TestSearchClassesReturnsPartitionError(t *testing.T) {
+	failed := errors.New("partition unavailable")
+	_, err := searchClasses([]ClassKey{{ClassName: "CS 537"}}, 3, true, func(ClassKey) ([]SearchResult, error) {
+		return nil, failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("err = %v, want the partition's error", err)
+	}
+}
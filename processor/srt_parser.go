@@ -1,12 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	tokenizer "github.com/sugarme/tokenizer"
 )
 
-// ParseSRT parses SRT transcript text and returns array of Frames.
+// ParseSRT parses SRT transcript text and returns array of Frames. Text lines belong to
+// the most recent timestamp line; orphan text before the first cue is dropped.
 func ParseSRT(transcriptText string) []Frame {
 	//	1									sequence number
 	//	00:00:00,000 --> 00:00:01,830		start --> end
@@ -22,64 +30,317 @@ func ParseSRT(transcriptText string) []Frame {
 		return []Frame{}
 	}
 
-	lines := strings.Split(transcriptText, "\n")
+	// A strings.Reader never fails, so neither does parsing it
+	frames, _ := ParseSRTReader(strings.NewReader(transcriptText))
+	return frames
+}
+
+// ParseSRTReader parses SRT text line by line from r, so a long transcript is never
+// split into one big slice of lines. It returns the frames parsed before any read error.
+// Lines may end in LF or CRLF and a leading UTF-8 BOM is ignored; see ParseSRT for how
+// text is assigned to cues.
+func ParseSRTReader(r io.Reader) ([]Frame, error) {
+	reader := bufio.NewReader(r)
 	var frames []Frame
+	seenCue := false // no text is kept until the first timestamp line
 	var currentStartTime string
 	var currentEndTime string
+	var currentStartMillis int64 = -1
+	var currentEndMillis int64 = -1
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-
-		// Skip empty lines
-		if line == "" {
-			continue
+	for firstLine := true; ; firstLine = false {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return frames, fmt.Errorf("error reading transcript: %w", err)
 		}
+		atEOF := err == io.EOF
 
-		// Skip sequence numbers
-		if isDigitOnly(line) {
-			continue
+		// TrimSpace also drops the \r of a CRLF ending
+		if firstLine {
+			line = stripBOM(line)
 		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		// Skip empty lines and sequence numbers
+		case line == "" || isDigitOnly(line):
 
 		// timestamp line (start --> end)
 		// HH:MM:SS,mmm --> HH:MM:SS,mmm
-		if strings.Contains(line, "-->") {
+		case strings.Contains(line, "-->"):
 			parts := strings.Split(line, "-->")
 			if len(parts) == 2 {
 				currentStartTime = strings.TrimSpace(parts[0])
 				currentEndTime = strings.TrimSpace(parts[1])
+				currentStartMillis = ParseSRTTimestamp(currentStartTime)
+				currentEndMillis = ParseSRTTimestamp(currentEndTime)
+				seenCue = true
 			}
-			continue
-		}
+
+		// Orphan text before any cue has no time to attach to
+		case !seenCue:
 
 		// Create frame
+		default:
+			frames = append(frames, Frame{
+				Text:        line,
+				StartTime:   currentStartTime,
+				EndTime:     currentEndTime,
+				StartMillis: currentStartMillis,
+				EndMillis:   currentEndMillis,
+			})
+		}
+
+		if atEOF {
+			return frames, nil
+		}
+	}
+}
+
+// HasSRTTimestamps reports whether the transcript contains any "start --> end" lines
+func HasSRTTimestamps(transcriptText string) bool {
+	for _, line := range strings.Split(transcriptText, "\n") {
+		if strings.Contains(line, "-->") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePlainText splits an untimed transcript into one frame per non-empty line.
+// Timestamps are unavailable, so every frame has empty times and -1 millis.
+func ParsePlainText(transcriptText string) []Frame {
+	var frames []Frame
+	for _, line := range strings.Split(stripBOM(transcriptText), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
 		frames = append(frames, Frame{
-			Text:      line,
-			StartTime: currentStartTime,
-			EndTime:   currentEndTime,
+			Text:        line,
+			StartMillis: -1,
+			EndMillis:   -1,
 		})
 	}
-
 	return frames
 }
 
-// ExtractSentencesFromFrames merges frames into sentences based on sentence boundaries
-// A sentence is text ending with . or ? or !
-func (em *EmbeddingModel) ExtractSentencesFromFrames(frames []Frame) []*Sentence {
+// TranscriptOptions controls how ParseTranscript reads and cleans up a transcript
+type TranscriptOptions struct {
+	DetectPlainText      bool // Fall back to ParsePlainText when there are no timestamp lines
+	MergeDuplicateFrames bool // Drop frames repeating the previous frame's text (rolling captions)
+
+	SpeakerPatterns []*regexp.Regexp // Leading speaker labels to strip (see StripSpeakerLabels), nil keeps them
+}
+
+// ParseTranscript parses SRT text, falling back to ParsePlainText when DetectPlainText is
+// set and the input has no timestamp lines. untimed reports whether the fallback was used.
+func ParseTranscript(transcriptText string, opts TranscriptOptions) (frames []Frame, untimed bool) {
+	if opts.DetectPlainText && transcriptText != "" && !HasSRTTimestamps(transcriptText) {
+		frames, untimed = ParsePlainText(transcriptText), true
+	} else {
+		frames = ParseSRT(transcriptText)
+	}
+
+	if opts.SpeakerPatterns != nil {
+		frames = StripSpeakerLabels(frames, opts.SpeakerPatterns)
+	}
+	if opts.MergeDuplicateFrames {
+		frames = MergeDuplicateFrames(frames)
+	}
+	return frames, untimed
+}
+
+// DefaultSpeakerPatterns match common leading speaker labels: ">> JOHN:" or a bare ">>",
+// "[Instructor]" or "(Student):", and an all-caps name followed by a colon such as
+// "PROFESSOR SMITH:". Patterns must be anchored at the start of the line; a colon
+// elsewhere in the text ("the ratio is 3:1", "Note: ...") is left alone.
+var DefaultSpeakerPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^>>+\s*(?:[A-Z][A-Z0-9 .'-]{0,39}:\s*)?`),
+	regexp.MustCompile(`^\[[^\]]{1,40}\]\s*:?\s*`),
+	regexp.MustCompile(`^\([^)]{1,40}\):\s*`), // "(x + y) squared" is content, "(Student): ..." isn't
+	regexp.MustCompile(`^[A-Z][A-Z0-9 .'-]{0,39}:\s+`),
+}
+
+// StripSpeakerLabels removes a leading speaker label matching any of patterns from each
+// frame's text. Frames left with no text (a label on its own line) are dropped.
+func StripSpeakerLabels(frames []Frame, patterns []*regexp.Regexp) []Frame {
+	stripped := frames[:0:0]
+	for _, frame := range frames {
+		for _, pattern := range patterns {
+			if loc := pattern.FindStringIndex(frame.Text); loc != nil && loc[0] == 0 {
+				frame.Text = strings.TrimSpace(frame.Text[loc[1]:])
+				break
+			}
+		}
+		if frame.Text != "" {
+			stripped = append(stripped, frame)
+		}
+	}
+	return stripped
+}
+
+// MergeDuplicateFrames drops each frame whose text equals the immediately preceding
+// frame's, extending the kept frame's end time so it keeps the earliest start and
+// covers the repeat. Frames are compared after trimming whitespace.
+func MergeDuplicateFrames(frames []Frame) []Frame {
+	if len(frames) < 2 {
+		return frames
+	}
+
+	merged := make([]Frame, 0, len(frames))
+	merged = append(merged, frames[0])
+	for _, frame := range frames[1:] {
+		last := &merged[len(merged)-1]
+		if strings.TrimSpace(frame.Text) != strings.TrimSpace(last.Text) {
+			merged = append(merged, frame)
+			continue
+		}
+		if frame.EndMillis > last.EndMillis {
+			last.EndTime = frame.EndTime
+			last.EndMillis = frame.EndMillis
+		}
+	}
+	return merged
+}
+
+// ParseSRTTimestamp converts HH:MM:SS,mmm (or HH:MM:SS.mmm) into milliseconds. A
+// fraction of one or two digits is a tenth or hundredth of a second (",5" is 500ms).
+// Returns -1 if the timestamp is malformed, including a sign on any field.
+func ParseSRTTimestamp(ts string) int64 {
+	ts = strings.TrimSpace(ts)
+
+	// Accept both comma and period as the millisecond separator
+	sep := strings.LastIndexAny(ts, ",.")
+	if sep == -1 {
+		return -1
+	}
+	clock, msPart := ts[:sep], ts[sep+1:]
+
+	parts := strings.Split(clock, ":")
+	if len(parts) != 3 || len(msPart) > 3 || !isDigitOnly(msPart) {
+		return -1
+	}
+	// ParseInt accepts a leading sign, so check for digits only first
+	for _, part := range parts {
+		if !isDigitOnly(part) {
+			return -1
+		}
+	}
+
+	hours, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return -1
+	}
+	minutes, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || minutes >= 60 {
+		return -1
+	}
+	seconds, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || seconds >= 60 {
+		return -1
+	}
+	millis, err := strconv.ParseInt(msPart, 10, 64)
+	if err != nil {
+		return -1
+	}
+	for i := len(msPart); i < 3; i++ {
+		millis *= 10
+	}
+
+	return ((hours*60+minutes)*60+seconds)*1000 + millis
+}
+
+// SentenceSplitter decides where sentences end while frames are being merged
+type SentenceSplitter interface {
+	// IsBoundary reports whether text (the sentence accumulated so far) ends a sentence.
+	// next is the text of the following frame, "" after the last one.
+	IsBoundary(text, next string) bool
+}
+
+// RegexSentenceSplitter treats terminal . ! ? (optionally followed by closing
+// quotes/brackets) as a boundary, except after known abbreviations and ellipses, and
+// except a digit and period followed by a frame starting with a digit, which is a
+// decimal split across frames ("is 3." then "14 or so").
+type RegexSentenceSplitter struct {
+	terminal     *regexp.Regexp
+	abbreviation *regexp.Regexp
+	ellipsis     *regexp.Regexp
+	decimal      *regexp.Regexp
+}
+
+// DefaultAbbreviations are words ending in "." that do not end a sentence
+var DefaultAbbreviations = []string{
+	"e.g", "i.e", "dr", "mr", "mrs", "ms", "prof", "vs", "fig", "eq", "approx", "cf", "st", "jr", "sr",
+}
+
+// NewRegexSentenceSplitter builds a splitter that ignores the given abbreviations
+// (case-insensitive, without the trailing period). nil uses DefaultAbbreviations.
+func NewRegexSentenceSplitter(abbreviations []string) *RegexSentenceSplitter {
+	if abbreviations == nil {
+		abbreviations = DefaultAbbreviations
+	}
+
+	splitter := &RegexSentenceSplitter{
+		terminal: regexp.MustCompile(`[.!?]["')\]]*$`),
+		ellipsis: regexp.MustCompile(`(\.\.\.|…)["')\]]*$`), // speaker trailing off mid-thought
+		decimal:  regexp.MustCompile(`\d\.$`),
+	}
+
+	if len(abbreviations) > 0 {
+		quoted := make([]string, len(abbreviations))
+		for i, a := range abbreviations {
+			quoted[i] = regexp.QuoteMeta(a)
+		}
+		splitter.abbreviation = regexp.MustCompile(`(?i)(^|[\s(])(` + strings.Join(quoted, "|") + `)\.$`)
+	}
+
+	return splitter
+}
+
+// IsBoundary implements SentenceSplitter
+func (r *RegexSentenceSplitter) IsBoundary(text, next string) bool {
+	text = strings.TrimSpace(text)
+	if !r.terminal.MatchString(text) {
+		return false
+	}
+	if r.ellipsis.MatchString(text) {
+		return false
+	}
+	if next = strings.TrimSpace(next); next != "" && next[0] >= '0' && next[0] <= '9' && r.decimal.MatchString(text) {
+		return false
+	}
+	if r.abbreviation != nil && r.abbreviation.MatchString(text) {
+		return false
+	}
+	return true
+}
+
+// ExtractSentencesFromFrames merges frames into sentences based on sentence boundaries.
+// Boundaries are only checked at the end of a frame; splitter decides whether the
+// text so far ends a sentence (nil uses NewRegexSentenceSplitter(nil)). Sentences over
+// maxTokens (normally ChunkingConfig.MaxSize) are split at word boundaries.
+func (em *EmbeddingModel) ExtractSentencesFromFrames(frames []Frame, splitter SentenceSplitter, maxTokens int) []*Sentence {
 	if len(frames) == 0 {
 		return []*Sentence{}
 	}
+	if splitter == nil {
+		splitter = NewRegexSentenceSplitter(nil)
+	}
 
 	// Merge all frame text together, keeping track of where each starts
 	var sentences []*Sentence
 
 	var currentSentenceText strings.Builder
 	var currentStartTime string
+	var currentStartMillis int64 = -1
 	var isFirstFrame = true
 
-	for _, frame := range frames {
+	for i, frame := range frames {
 		// Set start time for first frame of this sentence
 		if isFirstFrame {
 			currentStartTime = frame.StartTime
+			currentStartMillis = frame.StartMillis
 			isFirstFrame = false
 		}
 
@@ -89,16 +350,20 @@ func (em *EmbeddingModel) ExtractSentencesFromFrames(frames []Frame) []*Sentence
 		}
 		currentSentenceText.WriteString(frame.Text)
 
-		// Check if this frame ends with . or ? or !
-		trimmed := strings.TrimSpace(frame.Text)
-		if strings.HasSuffix(trimmed, ".") || strings.HasSuffix(trimmed, "!") || strings.HasSuffix(trimmed, "?") {
-			sentenceText := currentSentenceText.String()
+		// Check if this frame ends the sentence
+		next := ""
+		if i+1 < len(frames) {
+			next = frames[i+1].Text
+		}
+		if splitter.IsBoundary(currentSentenceText.String(), next) {
+			sentenceText := normalizeWhitespace(currentSentenceText.String())
 
 			sentences = append(sentences, &Sentence{
-				Text:       sentenceText,
-				StartTime:  currentStartTime,
-				Embedding:  nil, // Will be populated by embedding function
-				TokenCount: CountTokens(em.Tokenizer, sentenceText),
+				Text:        sentenceText,
+				StartTime:   currentStartTime,
+				StartMillis: currentStartMillis,
+				EndTime:     frame.EndTime,
+				Embedding:   nil, // Will be populated by embedding function
 			})
 
 			currentSentenceText.Reset()
@@ -107,70 +372,133 @@ func (em *EmbeddingModel) ExtractSentencesFromFrames(frames []Frame) []*Sentence
 	}
 
 	// Add any remaining text as a sentence
-	if currentSentenceText.Len() > 0 {
-		sentenceText := currentSentenceText.String()
+	if sentenceText := normalizeWhitespace(currentSentenceText.String()); sentenceText != "" {
 		sentences = append(sentences, &Sentence{
-			Text:       sentenceText,
-			StartTime:  currentStartTime,
-			Embedding:  nil,
-			TokenCount: CountTokens(em.Tokenizer, sentenceText),
+			Text:        sentenceText,
+			StartTime:   currentStartTime,
+			StartMillis: currentStartMillis,
+			EndTime:     frames[len(frames)-1].EndTime,
+			Embedding:   nil,
 		})
 	}
 
-	// Post-process: split any oversized sentences (>512 tokens) into smaller chunks
+	// Count every sentence's tokens in one tokenizer pass
+	texts := make([]string, len(sentences))
+	for i, sent := range sentences {
+		texts[i] = sent.Text
+	}
+	for i, count := range CountTokensBatch(em.Tokenizer, texts) {
+		sentences[i].TokenCount = count
+	}
+
+	// Post-process: split any oversized sentences (>maxTokens) into smaller chunks
 	// This prevents the DP algorithm from failing when individual sentences are too large
-	maxTokens := 512
 	finalSentences := make([]*Sentence, 0, len(sentences))
 
 	for _, sent := range sentences {
-		if sent.TokenCount <= maxTokens {
+		// A count at the limit may have been truncated by the tokenizer, so check it too
+		if maxTokens <= 0 || sent.TokenCount < maxTokens {
 			finalSentences = append(finalSentences, sent)
 			continue
 		}
+		finalSentences = append(finalSentences, em.splitOversizedSentence(sent, maxTokens)...)
+	}
 
-		// Split oversized sentence by words
-		words := strings.Fields(sent.Text)
-		if len(words) == 0 {
-			finalSentences = append(finalSentences, sent)
-			continue
-		}
+	return finalSentences
+}
 
-		// Binary search to find how many words fit in maxTokens
-		var currentChunk strings.Builder
-		for len(words) > 0 {
-			// Start with first word
-			currentChunk.Reset()
-			currentChunk.WriteString(words[0])
-			wordCount := 1
+// ResplitSentence splits a sentence over maxTokens like ExtractSentencesFromFrames does
+// and embeds the pieces, so each has its real token count and its own embedding. Used as
+// ChunkingConfig.Resplit.
+func (em *EmbeddingModel) ResplitSentence(sent *Sentence, maxTokens int) ([]*Sentence, error) {
+	pieces := em.splitOversizedSentence(sent, maxTokens)
+	if len(pieces) == 1 && pieces[0] == sent {
+		return pieces, nil
+	}
+	if err := em.EmbedSentences(pieces); err != nil {
+		return nil, fmt.Errorf("failed to embed sentence pieces: %w", err)
+	}
+	return pieces, nil
+}
 
-			// Add words until we hit token limit
-			for wordCount < len(words) {
-				testText := currentChunk.String() + " " + words[wordCount]
-				tokens := CountTokens(em.Tokenizer, testText)
+// splitOversizedSentence splits sent at word boundaries into sub-sentences of at most
+// maxTokens. The sentence is tokenized once, word by word in a single batch, and words are
+// packed greedily by those counts, so splitting is linear in the sentence length. Pieces
+// whose joined text still tokenizes over the limit are halved until they fit; only a
+// single word over maxTokens is left as is.
+func (em *EmbeddingModel) splitOversizedSentence(sent *Sentence, maxTokens int) []*Sentence {
+	words := strings.Fields(sent.Text)
+	if len(words) == 0 {
+		return []*Sentence{sent}
+	}
 
-				if tokens > maxTokens {
-					break
-				}
+	wordTokens := CountTokensBatch(em.Tokenizer, words)
+	total := 0
+	for _, n := range wordTokens {
+		total += n
+	}
+	if total <= maxTokens {
+		return []*Sentence{sent} // at the limit but not over it
+	}
 
-				currentChunk.WriteString(" ")
-				currentChunk.WriteString(words[wordCount])
-				wordCount++
-			}
+	// Greedily pack words into [start, end) ranges up to maxTokens
+	type wordRange struct{ start, end int }
+	var ranges []wordRange
+	for start := 0; start < len(words); {
+		end := start + 1
+		tokens := wordTokens[start]
+		for end < len(words) && tokens+wordTokens[end] <= maxTokens {
+			tokens += wordTokens[end]
+			end++
+		}
+		ranges = append(ranges, wordRange{start, end})
+		start = end
+	}
 
-			// Create sub-sentence
-			chunkText := currentChunk.String()
-			finalSentences = append(finalSentences, &Sentence{
-				Text:       chunkText,
-				StartTime:  sent.StartTime,
-				Embedding:  nil,
-				TokenCount: CountTokens(em.Tokenizer, chunkText),
-			})
+	// Sub-sentences keyed by their first word, since halved pieces finish out of order
+	done := make(map[int]*Sentence)
+	for len(ranges) > 0 {
+		pieces := make([]string, len(ranges))
+		for i, r := range ranges {
+			pieces[i] = strings.Join(words[r.start:r.end], " ")
+		}
 
-			words = words[wordCount:]
+		// Keep pieces that fit, halve the rest and recount them in the next pass
+		var over []wordRange
+		for i, count := range CountTokensBatch(em.Tokenizer, pieces) {
+			r := ranges[i]
+			if count > maxTokens && r.end-r.start > 1 {
+				mid := (r.start + r.end) / 2
+				over = append(over, wordRange{r.start, mid}, wordRange{mid, r.end})
+				continue
+			}
+			if count > maxTokens {
+				slog.Warn("Word exceeds the token limit, keeping it as one sentence",
+					"token_count", count, "token_limit", maxTokens)
+			}
+			done[r.start] = &Sentence{
+				Text:        pieces[i],
+				StartTime:   sent.StartTime,
+				StartMillis: sent.StartMillis,
+				EndTime:     sent.EndTime,
+				Embedding:   nil,
+				TokenCount:  count,
+			}
 		}
+		ranges = over
 	}
 
-	return finalSentences
+	starts := make([]int, 0, len(done))
+	for start := range done {
+		starts = append(starts, start)
+	}
+	sort.Ints(starts)
+
+	result := make([]*Sentence, len(starts))
+	for i, start := range starts {
+		result[i] = done[start]
+	}
+	return result
 }
 
 func CountTokens(tok *tokenizer.Tokenizer, text string) int {
@@ -182,6 +510,44 @@ func CountTokens(tok *tokenizer.Tokenizer, text string) int {
 	return len(encoding.GetIds())
 }
 
+// CountTokensBatch returns the token count of each text, tokenizing them all in one
+// EncodeBatch call. Counts match CountTokens; if the batch fails, each text is counted
+// separately.
+func CountTokensBatch(tok *tokenizer.Tokenizer, texts []string) []int {
+	counts := make([]int, len(texts))
+	if len(texts) == 0 {
+		return counts
+	}
+
+	inputs := make([]tokenizer.EncodeInput, len(texts))
+	for i, t := range texts {
+		inputs[i] = tokenizer.NewSingleEncodeInput(tokenizer.NewInputSequence(t))
+	}
+
+	encodings, err := tok.EncodeBatch(inputs, false)
+	if err != nil || len(encodings) != len(texts) {
+		for i, t := range texts {
+			counts[i] = CountTokens(tok, t)
+		}
+		return counts
+	}
+
+	for i, enc := range encodings {
+		// Count attended tokens only, in case the tokenizer pads the batch
+		for _, m := range enc.GetAttentionMask() {
+			if m != 0 {
+				counts[i]++
+			}
+		}
+	}
+	return counts
+}
+
+// stripBOM removes a leading UTF-8 byte order mark, which TrimSpace does not treat as space
+func stripBOM(s string) string {
+	return strings.TrimPrefix(s, "\ufeff")
+}
+
 // checks if a string contains only digits
 func isDigitOnly(s string) bool {
 	for _, r := range s {
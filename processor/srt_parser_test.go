@@ -0,0 +1,215 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sugarme/tokenizer/pretrained"
+)
+
+func TestParseSRT(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Frame
+	}{
+		{
+			name:  "empty input",
+			input: "",
+			want:  []Frame{},
+		},
+		{
+			name:  "single cue",
+			input: "1\n00:00:00,000 --> 00:00:01,830\nI'm happy to\n",
+			want: []Frame{
+				{Text: "I'm happy to", StartTime: "00:00:00,000", EndTime: "00:00:01,830", StartMillis: 0, EndMillis: 1830},
+			},
+		},
+		{
+			name:  "multi-line cue",
+			input: "1\n00:00:00,000 --> 00:00:01,830\nI'm happy to\nhave you here today.\n\n2\n00:00:01,910 --> 00:00:03,610\nAs I'm sure\n",
+			want: []Frame{
+				{Text: "I'm happy to", StartTime: "00:00:00,000", EndTime: "00:00:01,830", StartMillis: 0, EndMillis: 1830},
+				{Text: "have you here today.", StartTime: "00:00:00,000", EndTime: "00:00:01,830", StartMillis: 0, EndMillis: 1830},
+				{Text: "As I'm sure", StartTime: "00:00:01,910", EndTime: "00:00:03,610", StartMillis: 1910, EndMillis: 3610},
+			},
+		},
+		{
+			name:  "CRLF line endings",
+			input: "1\r\n00:00:00,000 --> 00:00:01,830\r\nI'm happy to\r\nhave you here today.\r\n\r\n",
+			want: []Frame{
+				{Text: "I'm happy to", StartTime: "00:00:00,000", EndTime: "00:00:01,830", StartMillis: 0, EndMillis: 1830},
+				{Text: "have you here today.", StartTime: "00:00:00,000", EndTime: "00:00:01,830", StartMillis: 0, EndMillis: 1830},
+			},
+		},
+		{
+			name:  "no trailing newline",
+			input: "1\n00:00:01.500 --> 00:00:02.000\nlast line",
+			want: []Frame{
+				{Text: "last line", StartTime: "00:00:01.500", EndTime: "00:00:02.000", StartMillis: 1500, EndMillis: 2000},
+			},
+		},
+		{
+			name:  "missing timestamps",
+			input: "1\nno timestamp here\n\n2\nstill none\n",
+			want:  nil,
+		},
+		{
+			name:  "text before first timestamp dropped",
+			input: "orphan\n1\n00:00:00,000 --> 00:00:01,000\nkept\n",
+			want: []Frame{
+				{Text: "kept", StartTime: "00:00:00,000", EndTime: "00:00:01,000", StartMillis: 0, EndMillis: 1000},
+			},
+		},
+		{
+			name:  "malformed timestamp",
+			input: "1\n00:00 --> 00:00:01,000\ntext\n",
+			want: []Frame{
+				{Text: "text", StartTime: "00:00", EndTime: "00:00:01,000", StartMillis: -1, EndMillis: 1000},
+			},
+		},
+		{
+			name:  "byte order mark",
+			input: "\ufeff1\n00:00:00,000 --> 00:00:01,000\ntext\n",
+			want: []Frame{
+				{Text: "text", StartTime: "00:00:00,000", EndTime: "00:00:01,000", StartMillis: 0, EndMillis: 1000},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseSRT(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSRT(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSRTTimestamp(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"00:00:00,000", 0},
+		{"01:02:03,456", 3723456},
+		{"00:00:01.830", 1830},
+		{" 00:00:01,000 ", 1000},
+		{"00:00:01,5", 1500},
+		{"00:00:01,05", 1050},
+		{"00:00:01.50", 1500},
+		{"00:00:01", -1},
+		{"00:60:00,000", -1},
+		{"00:00:00,1000", -1},
+		{"aa:00:00,000", -1},
+		{"+1:00:00,000", -1},
+		{"00:+1:00,000", -1},
+		{"00:00:+1,000", -1},
+		{"00:00:01,+50", -1},
+		{"-1:00:00,000", -1},
+		{"00::01,000", -1},
+		{"00:00:01,", -1},
+		{"", -1},
+	}
+
+	for _, tt := range tests {
+		if got := ParseSRTTimestamp(tt.input); got != tt.want {
+			t.Errorf("ParseSRTTimestamp(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseTranscriptPlainTextIsUntimed(t *testing.T) {
+	text := "Welcome back, everyone.\r\n\r\nToday we cover paging.\n   \nAny questions?"
+	frames, untimed := ParseTranscript(text, TranscriptOptions{DetectPlainText: true})
+	if !untimed {
+		t.Error("untimed = false for a transcript without timestamps")
+	}
+
+	want := []Frame{
+		{Text: "Welcome back, everyone.", StartMillis: -1, EndMillis: -1},
+		{Text: "Today we cover paging.", StartMillis: -1, EndMillis: -1},
+		{Text: "Any questions?", StartMillis: -1, EndMillis: -1},
+	}
+	if !reflect.DeepEqual(frames, want) {
+		t.Errorf("frames = %+v, want %+v", frames, want)
+	}
+
+	// The flag is carried onto every stored row
+	chunks := []*Chunk{{Text: "Welcome back, everyone. Today we cover paging.", StartMillis: -1}}
+	rows := buildEmbeddingsRows(&TranscriptEvent{URL: "u"}, chunks, untimed, &EmbeddingModel{}, &ProcessorConfig{})
+	if !rows[0].Untimed || rows[0].LectureStartMs != -1 {
+		t.Errorf("row Untimed=%v LectureStartMs=%d, want true and -1", rows[0].Untimed, rows[0].LectureStartMs)
+	}
+}
+
+func TestParseTranscriptTimedOrUndetected(t *testing.T) {
+	srt := "1\n00:00:00,000 --> 00:00:01,000\nhello\n"
+	if frames, untimed := ParseTranscript(srt, TranscriptOptions{DetectPlainText: true}); untimed || len(frames) != 1 {
+		t.Errorf("SRT input: untimed=%v frames=%d, want timed with 1 frame", untimed, len(frames))
+	}
+
+	// Without detection, plain text has no cues and yields nothing
+	if frames, untimed := ParseTranscript("just text\n", TranscriptOptions{}); untimed || len(frames) != 0 {
+		t.Errorf("detection off: untimed=%v frames=%d, want no frames", untimed, len(frames))
+	}
+
+	if frames, untimed := ParseTranscript("", TranscriptOptions{DetectPlainText: true}); untimed || len(frames) != 0 {
+		t.Errorf("empty input: untimed=%v frames=%d, want no frames", untimed, len(frames))
+	}
+}
+
+func TestRegexSentenceSplitter(t *testing.T) {
+	splitter := NewRegexSentenceSplitter(nil)
+	tests := []struct {
+		text, next string
+		want       bool
+	}{
+		{"That's the end.", "Next topic", true},
+		{"Is that clear?", "", true},
+		{`He said "stop."`, "Then", true},
+		{"(see the notes.)", "Now", true},
+		{"no punctuation here", "and more", false},
+		{"use a lock, e.g.", "a mutex", false},
+		{"as E.G.", "shown", false},
+		{"ask Dr.", "Smith", false},
+		{"this one vs.", "that one", false},
+		{"and then...", "we move on", false},
+		{"and then…", "we move on", false},
+		{"pi is 3.", "14 or so", false},
+		{"pi is 3.", " 14 or so", false},
+		{"pi is 3.14.", "Next", true},
+		{"we covered chapter 3.", "Next we", true},
+		{"we covered chapter 3.", "", true},
+		{"the answer is three.", "14 people got it", true},
+	}
+
+	for _, tt := range tests {
+		if got := splitter.IsBoundary(tt.text, tt.next); got != tt.want {
+			t.Errorf("IsBoundary(%q, %q) = %v, want %v", tt.text, tt.next, got, tt.want)
+		}
+	}
+}
+
+func TestExtractSentencesFromFramesKeepsSplitDecimal(t *testing.T) {
+	tok, err := pretrained.FromFile("tokenizer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	em := &EmbeddingModel{Tokenizer: tok}
+
+	frames := []Frame{
+		{Text: "The ratio is 3.", StartTime: "00:00:00,000", EndTime: "00:00:01,000"},
+		{Text: "14 on average.", StartTime: "00:00:01,000", EndTime: "00:00:02,000"},
+		{Text: "Any questions?", StartTime: "00:00:02,000", EndTime: "00:00:03,000"},
+	}
+	var got []string
+	for _, s := range em.ExtractSentencesFromFrames(frames, nil, 0) {
+		got = append(got, s.Text)
+	}
+
+	want := []string{"The ratio is 3. 14 on average.", "Any questions?"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sentences = %q, want %q", got, want)
+	}
+}
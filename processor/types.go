@@ -1,29 +1,39 @@
 package main
 
+import "time"
+
 // Frame: a single line from the SRT transcript
 type Frame struct {
-	Text      string
-	StartTime string // HH:MM:SS.mmm format
-	EndTime   string
+	Text        string
+	StartTime   string // HH:MM:SS.mmm format
+	EndTime     string
+	StartMillis int64 // StartTime in milliseconds, -1 if malformed
+	EndMillis   int64 // EndTime in milliseconds, -1 if malformed
 }
 
 // Sentence: a single complete sentence
 type Sentence struct {
-	Text       string
-	StartTime  string // From first frame that contributed to this sentence
-	Embedding  []float32
-	TokenCount int
+	Text        string
+	StartTime   string // From first frame that contributed to this sentence
+	StartMillis int64
+	EndTime     string // From last frame that contributed to this sentence
+	Embedding   []float32
+	TokenCount  int
 }
 
 // Chunk: semantically grouped sentences, formed by merging sentences based on embedding similarity
 type Chunk struct {
 	Text               string
 	StartTime          string
+	StartMillis        int64
+	EndTime            string // From last sentence in this chunk
 	Embedding          []float32
 	NumSentences       int
 	TokenCount         int
 	ChunkIndex         int
 	SentenceEmbeddings [][]float32 // Individual sentence embeddings
+	Sentences          []*Sentence // Sentences making up the chunk, excluding overlap
+	ContinuesPrevious  bool        // Boundary with the previous chunk is highly similar
 }
 
 // Transcript holds metadata about a lecture transcript
@@ -39,14 +49,47 @@ type Transcript struct {
 
 // EmbeddingsRow: a row to insert into the embeddings table
 type EmbeddingsRow struct {
+	ClassName         string
+	Professor         string
+	Semester          string
+	URL               string
+	ChunkIndex        int
+	ChunkText         string
+	Embedding         []float32
+	TokenCount        int
+	Keywords          []string // top TF-IDF terms, nil if disabled
+	LectureTitle      string
+	LectureOrder      int // lecture_number, or derived from the title when missing
+	LectureTimestamp  string
+	LectureStartMs    int64 // sortable start time, -1 if unknown
+	LectureEndTime    string
+	ContinuesPrevious bool
+	Untimed           bool // transcript had no timestamps, so the lecture times are unavailable
+	ModelName         string
+	EmbeddingDim      int
+}
+
+// SentenceEmbeddingsRow: a row to insert into the sentence_embeddings table
+type SentenceEmbeddingsRow struct {
 	ClassName        string
 	Professor        string
 	Semester         string
 	URL              string
-	ChunkIndex       int
-	ChunkText        string
+	SentenceIndex    int // position of the sentence in the lecture
+	ChunkIndex       int // chunk the sentence belongs to
+	SentenceText     string
 	Embedding        []float32
-	TokenCount       int
-	LectureTitle     string
 	LectureTimestamp string
+	LectureStartMs   int64 // sortable start time, -1 if unknown
+	ModelName        string
+}
+
+// ProcessResult: summary of one processed transcript, returned by process
+type ProcessResult struct {
+	Sentences      int
+	Chunks         int
+	TotalTokens    int
+	MinChunkTokens int // 0 if there are no chunks
+	MaxChunkTokens int
+	Duration       time.Duration
 }
@@ -1,14 +1,18 @@
 """Cassandra retrieval functions"""
+import os
 from cassandra.cluster import Cluster
 from sentence_transformers import SentenceTransformer
 
+# Instruction prefix for queries; must match the processor's EMBEDDING_QUERY_PREFIX
+QUERY_PREFIX = os.getenv('EMBEDDING_QUERY_PREFIX', '')
+
 def connect_db(hosts, keyspace):
     cluster = Cluster(hosts)
     session = cluster.connect(keyspace)
     return session
 
 def vector_search(session, embedding_model, query, class_name, professor, semester, limit=5):
-    embedding = embedding_model.encode(query, normalize_embeddings=True).tolist()
+    embedding = embedding_model.encode(QUERY_PREFIX + query, normalize_embeddings=True).tolist()
     results = session.execute("""
         SELECT url, chunk_index, chunk_text, lecture_title, lecture_timestamp
         FROM embeddings
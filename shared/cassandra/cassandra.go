@@ -0,0 +1,89 @@
+// Package cassandra holds the Cassandra connection setup shared by the watcher and the
+// processor, so both binaries talk to the cluster with the same settings
+package cassandra
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
+)
+
+// Options configures authentication and TLS. The zero value connects unauthenticated
+// over plaintext.
+type Options struct {
+	Username      string // Password authentication is used when set
+	Password      string
+	SSLEnabled    bool
+	CAPath        string // CA certificate for verifying the server, optional
+	SSLVerifyHost bool   // Verify the server certificate and hostname
+}
+
+// OptionsFromEnv reads CASSANDRA_USERNAME, CASSANDRA_PASSWORD, CASSANDRA_SSL_ENABLED,
+// CASSANDRA_CA_PATH and CASSANDRA_SSL_VERIFY_HOST (default: true)
+func OptionsFromEnv() Options {
+	sslEnabled, _ := strconv.ParseBool(os.Getenv("CASSANDRA_SSL_ENABLED"))
+
+	sslVerifyHost := true
+	if v, err := strconv.ParseBool(os.Getenv("CASSANDRA_SSL_VERIFY_HOST")); err == nil {
+		sslVerifyHost = v
+	}
+
+	return Options{
+		Username:      os.Getenv("CASSANDRA_USERNAME"),
+		Password:      os.Getenv("CASSANDRA_PASSWORD"),
+		SSLEnabled:    sslEnabled,
+		CAPath:        os.Getenv("CASSANDRA_CA_PATH"),
+		SSLVerifyHost: sslVerifyHost,
+	}
+}
+
+// SplitHosts splits a comma-separated host list, trimming whitespace and dropping empty
+// entries (e.g. from a trailing comma), which gocql can't connect to
+func SplitHosts(s string) []string {
+	var hosts []string
+	for _, host := range strings.Split(s, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// NewCluster returns the cluster config Connect uses, for callers that need to adjust it
+func NewCluster(hosts []string, keyspace string, opts Options) *gocql.ClusterConfig {
+	cluster := gocql.NewCluster(hosts...)
+	cluster.Keyspace = keyspace
+	cluster.Consistency = gocql.Quorum
+	cluster.Timeout = 10 * time.Second
+	cluster.ConnectTimeout = 10 * time.Second
+
+	// Plaintext and unauthenticated unless configured otherwise
+	if opts.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: opts.Username,
+			Password: opts.Password,
+		}
+	}
+	if opts.SSLEnabled {
+		cluster.SslOpts = &gocql.SslOptions{
+			CaPath:                 opts.CAPath,
+			EnableHostVerification: opts.SSLVerifyHost,
+		}
+	}
+
+	return cluster
+}
+
+// Connect opens a session to the cluster at hosts using keyspace, with QUORUM consistency
+func Connect(hosts []string, keyspace string, opts Options) (*gocql.Session, error) {
+	session, err := NewCluster(hosts, keyspace, opts).CreateSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Cassandra: %w", err)
+	}
+
+	return session, nil
+}
@@ -0,0 +1,58 @@
+package cassandra
+
+import (
+	"reflect"
+	"testing"
+
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
+)
+
+func TestSplitHosts(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"db-1", []string{"db-1"}},
+		{" db-1 , db-2,,db-3, ", []string{"db-1", "db-2", "db-3"}},
+	}
+	for _, tt := range tests {
+		if got := SplitHosts(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitHosts(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewCluster(t *testing.T) {
+	cluster := NewCluster([]string{"db-1"}, "transcript_db", Options{})
+	if cluster.Keyspace != "transcript_db" || cluster.Consistency != gocql.Quorum {
+		t.Errorf("keyspace=%q consistency=%v, want transcript_db QUORUM", cluster.Keyspace, cluster.Consistency)
+	}
+	if cluster.Authenticator != nil || cluster.SslOpts != nil {
+		t.Errorf("zero Options should be unauthenticated plaintext")
+	}
+
+	cluster = NewCluster([]string{"db-1"}, "ks", Options{
+		Username: "u", Password: "p", SSLEnabled: true, CAPath: "/ca.pem", SSLVerifyHost: true,
+	})
+	auth, ok := cluster.Authenticator.(gocql.PasswordAuthenticator)
+	if !ok || auth.Username != "u" || auth.Password != "p" {
+		t.Errorf("Authenticator = %#v, want password auth for u", cluster.Authenticator)
+	}
+	if cluster.SslOpts == nil || cluster.SslOpts.CaPath != "/ca.pem" || !cluster.SslOpts.EnableHostVerification {
+		t.Errorf("SslOpts = %#v, want CA /ca.pem with host verification", cluster.SslOpts)
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv("CASSANDRA_USERNAME", "u")
+	t.Setenv("CASSANDRA_PASSWORD", "p")
+	t.Setenv("CASSANDRA_SSL_ENABLED", "true")
+	t.Setenv("CASSANDRA_CA_PATH", "/ca.pem")
+	t.Setenv("CASSANDRA_SSL_VERIFY_HOST", "")
+
+	want := Options{Username: "u", Password: "p", SSLEnabled: true, CAPath: "/ca.pem", SSLVerifyHost: true}
+	if got := OptionsFromEnv(); got != want {
+		t.Errorf("OptionsFromEnv() = %+v, want %+v", got, want)
+	}
+}
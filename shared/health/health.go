@@ -0,0 +1,103 @@
+// Package health serves the /healthz and /readyz probes of the watcher and the processor
+package health
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health tracks main-loop liveness and dependency connectivity for the /healthz and
+// /readyz probes
+type Health struct {
+	mu              sync.Mutex
+	lastTick        time.Time
+	lastOK          map[string]time.Time
+	lastFailed      map[string]time.Time
+	livenessTimeout time.Duration
+}
+
+// New returns health state for the given dependencies, which are assumed
+// reachable at startup since the service connects to them before serving
+func New(livenessTimeout time.Duration, dependencies ...string) *Health {
+	now := time.Now()
+	h := &Health{
+		lastTick:        now,
+		lastOK:          make(map[string]time.Time),
+		lastFailed:      make(map[string]time.Time),
+		livenessTimeout: livenessTimeout,
+	}
+	for _, dep := range dependencies {
+		h.lastOK[dep] = now
+	}
+	return h
+}
+
+// Tick records that the main loop is making progress
+func (h *Health) Tick() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastTick = time.Now()
+}
+
+// MarkOK records a successful operation against a dependency
+func (h *Health) MarkOK(dependency string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastOK[dependency] = time.Now()
+}
+
+// MarkFailed records a connectivity failure against a dependency
+func (h *Health) MarkFailed(dependency string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastFailed[dependency] = time.Now()
+}
+
+// live reports whether the main loop ticked within the liveness timeout
+func (h *Health) live() (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	since := time.Since(h.lastTick)
+	if h.livenessTimeout > 0 && since > h.livenessTimeout {
+		return false, fmt.Sprintf("main loop last ticked %v ago", since.Round(time.Second))
+	}
+	return true, "ok"
+}
+
+// ready reports whether every dependency's latest operation succeeded
+func (h *Health) ready() (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for dep, failed := range h.lastFailed {
+		if failed.After(h.lastOK[dep]) {
+			return false, fmt.Sprintf("%s unreachable since %s", dep, failed.Format(time.RFC3339))
+		}
+	}
+	return true, "ok"
+}
+
+// Serve starts the probe server on addr in the background
+func (h *Health) Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", probeHandler(h.live))
+	mux.HandleFunc("/readyz", probeHandler(h.ready))
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Health server stopped", "error", err)
+		}
+	}()
+}
+
+func probeHandler(check func() (bool, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, msg := check()
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintln(w, msg)
+	}
+}
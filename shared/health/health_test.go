@@ -0,0 +1,37 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadyTracksLatestResult(t *testing.T) {
+	h := New(time.Minute, "redis")
+	if ok, msg := h.ready(); !ok {
+		t.Fatalf("ready at startup = false (%s), want true", msg)
+	}
+
+	h.MarkFailed("redis")
+	if ok, _ := h.ready(); ok {
+		t.Errorf("ready after a failure = true, want false")
+	}
+
+	time.Sleep(time.Millisecond)
+	h.MarkOK("redis")
+	if ok, msg := h.ready(); !ok {
+		t.Errorf("ready after recovering = false (%s), want true", msg)
+	}
+}
+
+func TestLiveFailsWithoutTicks(t *testing.T) {
+	h := New(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if ok, _ := h.live(); ok {
+		t.Errorf("live without a tick past the timeout = true, want false")
+	}
+
+	h.Tick()
+	if ok, msg := h.live(); !ok {
+		t.Errorf("live right after a tick = false (%s), want true", msg)
+	}
+}
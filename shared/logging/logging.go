@@ -0,0 +1,27 @@
+// Package logging sets up slog for the watcher and the processor
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup installs the default slog logger writing to w. LOG_FORMAT=json writes one JSON
+// object per line; otherwise (LOG_FORMAT=text or unset) each line is slog's text format,
+// time, level and message followed by key=value fields. The standard log package is
+// routed through the same handler.
+func Setup(w io.Writer) {
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "json") {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, nil)))
+		return
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, nil)))
+}
+
+// Fatal logs msg with err and exits non-zero
+func Fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
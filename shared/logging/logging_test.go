@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// setupCapture runs Setup with LOG_FORMAT=format into a buffer, restoring the previous
+// default logger when the test ends
+func setupCapture(t *testing.T, format string) *bytes.Buffer {
+	t.Helper()
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	t.Setenv("LOG_FORMAT", format)
+
+	var buf bytes.Buffer
+	Setup(&buf)
+	return &buf
+}
+
+func TestSetupText(t *testing.T) {
+	buf := setupCapture(t, "text")
+
+	slog.Debug("hidden")
+	slog.With("class_name", "CS 537").WithGroup("chunk").Info("Stored chunk", "index", 3)
+	log.Print("from log")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("debug record written at the default level:\n%s", out)
+	}
+	for _, want := range []string{`level=INFO msg="Stored chunk" class_name="CS 537" chunk.index=3`, `msg="from log"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSetupJSON(t *testing.T) {
+	buf := setupCapture(t, "JSON")
+
+	slog.Warn("Parser timed out", "parser_name", "cs537.py")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("output is not one JSON object: %v\n%s", err, buf)
+	}
+	if record["level"] != "WARN" || record["msg"] != "Parser timed out" || record["parser_name"] != "cs537.py" {
+		t.Errorf("record = %v", record)
+	}
+}